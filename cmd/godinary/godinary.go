@@ -4,12 +4,15 @@ import (
 	"flag"
 	"log"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 
 	raven "github.com/getsentry/raven-go"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"github.com/trilopin/godinary/http"
+	"github.com/trilopin/godinary/image"
 	"github.com/trilopin/godinary/storage"
 )
 
@@ -30,6 +33,11 @@ func setupConfig() {
 	flag.String("gce_project", "", "GS option: Sentry DSN for error tracking")
 	flag.String("gs_bucket", "", "GS option: Bucket name")
 	flag.String("gs_credentials", "", "GS option: Path to service account file with Google Storage credentials")
+	flag.String("face_cascade", "", "Path to pigo cascade file replacing the bundled one for face detection (g_face and g_faces gravities)")
+	flag.String("config", "", "Path to config file with named transformations")
+	flag.Bool("strict", false, "Strict mode: only named transformations (t_) and formats (f_) allowed in image urls")
+	flag.Bool("signed_urls", false, "Reject image urls without a valid signature (s--SIGNATURE--) made with an API secret")
+	flag.String("breakpoints", "", "Widths separated by commas that w_auto is rounded up to (defaults to 320,480,640,768,960,1024,1280,1440,1600,1920,2560)")
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 	pflag.Parse()
 	viper.BindPFlags(pflag.CommandLine)
@@ -70,7 +78,28 @@ func main() {
 		MaxRequestPerDomain: viper.GetInt("max_request_domain"),
 		SSLDir:              viper.GetString("ssl_dir"),
 		CDNTTL:              viper.GetString("cdn_ttl"),
+		Strict:              viper.GetBool("strict"),
+		SignedURLs:          viper.GetBool("signed_urls"),
 	}
+	if viper.GetString("config") != "" {
+		viper.SetConfigFile(viper.GetString("config"))
+		if err = viper.ReadInConfig(); err != nil {
+			log.Fatalln("can't read config file: ", err)
+		}
+	}
+	opts.Transformations = viper.GetStringMapString("transformations")
+
+	if breakpoints := viper.GetString("breakpoints"); breakpoints != "" {
+		for _, value := range strings.Split(breakpoints, ",") {
+			width, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || width <= 0 {
+				log.Fatalln("Invalid breakpoint ", value)
+			}
+			opts.Breakpoints = append(opts.Breakpoints, width)
+		}
+		sort.Ints(opts.Breakpoints)
+	}
+
 	opts.APIAuth = make(map[string]string)
 	auth := viper.GetString("auth")
 	if auth != "" {
@@ -108,5 +137,11 @@ func main() {
 		opts.StorageDriver = storage.NewFileDriver(opts.FSBase)
 	}
 
+	if viper.GetString("face_cascade") != "" {
+		if err = image.LoadFaceCascade(viper.GetString("face_cascade")); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
 	http.Serve(opts)
 }
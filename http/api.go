@@ -97,7 +97,11 @@ func APIUpload(opts *ServerOpts) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 		opts.StorageDriver.Write(body, hash, "upload/")
-		finalURL := fmt.Sprintf("https://%s/image/upload/f_auto/%s", opts.Domain, name)
+		urlData := "f_auto/" + name
+		if opts.SignedURLs {
+			urlData = SignURL(urlData, opts.APIAuth[r.FormValue("apikey")])
+		}
+		finalURL := fmt.Sprintf("https://%s/image/upload/%s", opts.Domain, urlData)
 		fmt.Println("Uploaded filename", finalURL)
 		b, err := json.Marshal(&UploadAPIResponse{URL: finalURL, Error: ""})
 		w.Write(b)
@@ -35,6 +35,10 @@ type ServerOpts struct {
 	GSBucket            string
 	GSCredentials       string
 	APIAuth             map[string]string
+	Transformations     map[string]string
+	Strict              bool
+	SignedURLs          bool
+	Breakpoints         []int
 }
 
 // ------------------------------------
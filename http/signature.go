@@ -0,0 +1,44 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// signatureLength is the number of characters kept from the encoded hmac
+const signatureLength = 16
+
+// Sign computes the signature of url data (filters and source) with the
+// given secret. Signed urls are built as s--SIGNATURE--/urldata
+func Sign(urlData string, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(urlData))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:signatureLength]
+}
+
+// SignURL prefixes url data with its signature
+func SignURL(urlData string, secret string) string {
+	return "s--" + Sign(urlData, secret) + "--/" + urlData
+}
+
+// verifySignature checks the signature of url data against every secret
+// and returns url data without signature and if it was signed
+func verifySignature(urlData string, secrets map[string]string) (string, bool, error) {
+	if !strings.HasPrefix(urlData, "s--") {
+		return urlData, false, nil
+	}
+	end := 3 + signatureLength
+	if len(urlData) < end+3 || urlData[end:end+3] != "--/" {
+		return urlData, false, errors.New("malformed signature")
+	}
+	signature, data := urlData[3:end], urlData[end+3:]
+	for _, secret := range secrets {
+		if hmac.Equal([]byte(signature), []byte(Sign(data, secret))) {
+			return data, true, nil
+		}
+	}
+	return data, false, errors.New("invalid signature")
+}
@@ -0,0 +1,41 @@
+package http
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var secrets = map[string]string{"key1": "secret1", "key2": "secret2"}
+
+func TestSign(t *testing.T) {
+	signature := Sign("w_100/file.jpg", "secret1")
+	assert.Len(t, signature, signatureLength)
+	assert.Equal(t, signature, Sign("w_100/file.jpg", "secret1"))
+	assert.NotEqual(t, signature, Sign("w_200/file.jpg", "secret1"))
+	assert.NotEqual(t, signature, Sign("w_100/file.jpg", "secret2"))
+}
+
+func TestVerifySignature(t *testing.T) {
+	cases := []struct {
+		urlData     string
+		expected    string
+		signed      bool
+		err         error
+		description string
+	}{
+		{"w_100/file.jpg", "w_100/file.jpg", false, nil, "not signed"},
+		{SignURL("w_100/file.jpg", "secret1"), "w_100/file.jpg", true, nil, "signed with first key"},
+		{SignURL("w_100/file.jpg", "secret2"), "w_100/file.jpg", true, nil, "signed with second key"},
+		{SignURL("w_100/file.jpg", "fake"), "w_100/file.jpg", false, errors.New("invalid signature"), "signed with unknown key"},
+		{"s--" + Sign("w_100/file.jpg", "secret1") + "--/w_200/file.jpg", "w_200/file.jpg", false, errors.New("invalid signature"), "tampered url"},
+		{"s--short--/w_100/file.jpg", "s--short--/w_100/file.jpg", false, errors.New("malformed signature"), "malformed signature"},
+	}
+	for _, test := range cases {
+		urlData, signed, err := verifySignature(test.urlData, secrets)
+		assert.Equal(t, test.err, err, test.description)
+		assert.Equal(t, test.signed, signed, test.description)
+		assert.Equal(t, test.expected, urlData, test.description)
+	}
+}
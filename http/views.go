@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -13,8 +14,8 @@ import (
 	"time"
 
 	raven "github.com/getsentry/raven-go"
+	"github.com/h2non/bimg"
 	"github.com/trilopin/godinary/image"
-	bimg "gopkg.in/h2non/bimg.v1"
 )
 
 // RobotsTXT return robots.txt valid for complete disallow
@@ -47,15 +48,29 @@ func Fetch(opts *ServerOpts) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 		urlInfo := strings.Replace(r.URL.Path, "/image/fetch/", "", 1)
+		urlInfo, signed, err := verifySignature(urlInfo, opts.APIAuth)
+		if err != nil || (opts.SignedURLs && !signed) {
+			http.Error(w, "Invalid signature", http.StatusForbidden)
+			return
+		}
 		job := image.NewJob()
+		job.Transformations = opts.Transformations
 		acceptHeader, ok := r.Header["Accept"]
 		job.AcceptWebp = ok && strings.Contains(acceptHeader[0], "image/webp")
+		job.AcceptAvif = ok && strings.Contains(acceptHeader[0], "image/avif")
+		job.Breakpoints = opts.Breakpoints
+		clientHints(job, r)
+		job.Context = r.Context()
 
 		if err := job.Parse(urlInfo, true); err != nil {
 			raven.CaptureErrorAndWait(err, nil)
 			http.Error(w, "Bad request", http.StatusBadRequest)
 			return
 		}
+		if opts.Strict && !job.Named && !signed {
+			http.Error(w, "Transformation not allowed", http.StatusForbidden)
+			return
+		}
 
 		domain, err := domainFromURL(job.Source.URL)
 		if err != nil || domain == "" {
@@ -73,7 +88,7 @@ func Fetch(opts *ServerOpts) func(http.ResponseWriter, *http.Request) {
 		if reader, err = opts.StorageDriver.NewReader(job.Target.Hash, "derived/"); err == nil {
 			defer reader.Close()
 			if cached, err2 := ioutil.ReadAll(reader); err2 == nil {
-				if err = writeImage(w, cached, job.Target.Format, opts); err == nil {
+				if err = writeImage(w, cached, job, opts); err == nil {
 					log.Printf("CACHED - TOTAL %0.5f", time.Since(t1).Seconds())
 				} else {
 					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -104,17 +119,15 @@ func Fetch(opts *ServerOpts) func(http.ResponseWriter, *http.Request) {
 		}
 		t2 := time.Now()
 
-		job.Source.ExtractInfo()
-		job.Crop()
-
 		// do the process thing
-		if err := job.Target.Process(job.Source, opts.StorageDriver); err != nil {
+		if err := job.Process(opts.StorageDriver); err != nil {
 			log.Printf("Error processing image %s, %v", job.Source.URL, err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
 		}
 		t3 := time.Now()
 
-		if err = writeImage(w, job.Target.RawContent, job.Target.Format, opts); err == nil {
+		if err = writeImage(w, job.Target.RawContent, job, opts); err == nil {
 			log.Printf(
 				"NEW - TOTAL %0.5f => SEM %0.5f, DOWN %0.5f, PROC %0.5f",
 				time.Since(t1).Seconds(), dSem,
@@ -143,21 +156,35 @@ func Upload(opts *ServerOpts) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 		urlInfo := strings.Replace(r.URL.Path, "/image/upload/", "", 1)
+		urlInfo, signed, err := verifySignature(urlInfo, opts.APIAuth)
+		if err != nil || (opts.SignedURLs && !signed) {
+			http.Error(w, "Invalid signature", http.StatusForbidden)
+			return
+		}
 		job := image.NewJob()
+		job.Transformations = opts.Transformations
 		acceptHeader, ok := r.Header["Accept"]
 		job.AcceptWebp = ok && strings.Contains(acceptHeader[0], "image/webp")
+		job.AcceptAvif = ok && strings.Contains(acceptHeader[0], "image/avif")
+		job.Breakpoints = opts.Breakpoints
+		clientHints(job, r)
+		job.Context = r.Context()
 
 		if err := job.Parse(urlInfo, false); err != nil {
 			raven.CaptureErrorAndWait(err, nil)
 			http.Error(w, "Bad request", http.StatusBadRequest)
 			return
 		}
+		if opts.Strict && !job.Named && !signed {
+			http.Error(w, "Transformation not allowed", http.StatusForbidden)
+			return
+		}
 
 		// derived image is already cached
 		if reader, err = opts.StorageDriver.NewReader(job.Target.Hash, "derived/"); err == nil {
 			defer reader.Close()
 			if cached, err2 := ioutil.ReadAll(reader); err2 == nil {
-				if err = writeImage(w, cached, job.Target.Format, opts); err == nil {
+				if err = writeImage(w, cached, job, opts); err == nil {
 					log.Printf("CACHED - TOTAL %0.5f", time.Since(t1).Seconds())
 				} else {
 					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -177,17 +204,15 @@ func Upload(opts *ServerOpts) func(http.ResponseWriter, *http.Request) {
 		}
 		t2 := time.Now()
 
-		job.Source.ExtractInfo()
-		job.Crop()
-
 		// do the process thing
-		if err := job.Target.Process(job.Source, opts.StorageDriver); err != nil {
+		if err := job.Process(opts.StorageDriver); err != nil {
 			log.Printf("Error processing image %s, %v", job.Source.URL, err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
 		}
 		t3 := time.Now()
 
-		if err = writeImage(w, job.Target.RawContent, job.Target.Format, opts); err == nil {
+		if err = writeImage(w, job.Target.RawContent, job, opts); err == nil {
 			log.Printf(
 				"NEW - TOTAL %0.5f =>  PROC %0.5f",
 				time.Since(t1).Seconds(), t3.Sub(t2).Seconds())
@@ -197,6 +222,39 @@ func Upload(opts *ServerOpts) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
+// clientDPR reads device pixel ratio client hint, 0 if not sent or not
+// a positive number
+func clientDPR(r *http.Request) float64 {
+	for _, header := range []string{"Sec-CH-DPR", "DPR"} {
+		dpr, err := strconv.ParseFloat(r.Header.Get(header), 64)
+		if err == nil && dpr > 0 && !math.IsInf(dpr, 0) {
+			return dpr
+		}
+	}
+	return 0
+}
+
+// acceptCH asks browsers for the client hints used by w_auto and dpr_auto
+const acceptCH = "Sec-CH-DPR, Sec-CH-Width, Sec-CH-Viewport-Width, Save-Data"
+
+// clientHints copies client hints from request to job
+func clientHints(job *image.Job, r *http.Request) {
+	job.ClientDPR = clientDPR(r)
+	job.ClientWidth = clientWidth(r, "Sec-CH-Width", "Width")
+	job.ClientViewportWidth = clientWidth(r, "Sec-CH-Viewport-Width", "Viewport-Width")
+	job.SaveData = strings.EqualFold(r.Header.Get("Save-Data"), "on")
+}
+
+// clientWidth reads the first width client hint sent, 0 if none
+func clientWidth(r *http.Request, headers ...string) int {
+	for _, header := range headers {
+		if width, err := strconv.Atoi(r.Header.Get(header)); err == nil && width > 0 {
+			return width
+		}
+	}
+	return 0
+}
+
 func domainFromURL(URL string) (string, error) {
 	info, err := url.Parse(URL)
 	if err != nil {
@@ -205,10 +263,14 @@ func domainFromURL(URL string) (string, error) {
 	return info.Host, nil
 }
 
-func writeImage(w http.ResponseWriter, buffer []byte, format bimg.ImageType, opts *ServerOpts) error {
+func writeImage(w http.ResponseWriter, buffer []byte, job *image.Job, opts *ServerOpts) error {
 	w.Header().Set("Cache-Control", "public, max-age="+opts.CDNTTL)
 	w.Header().Set("Content-Length", strconv.Itoa(len(buffer)))
-	w.Header().Set("Content-Type", fmt.Sprintf("image/%s", bimg.ImageTypes[format]))
+	w.Header().Set("Content-Type", fmt.Sprintf("image/%s", bimg.ImageTypes[job.Target.Format]))
+	w.Header().Set("Accept-CH", acceptCH)
+	if len(job.Vary) > 0 {
+		w.Header().Set("Vary", strings.Join(job.Vary, ", "))
+	}
 	_, err := w.Write(buffer)
 
 	if err != nil {
@@ -7,9 +7,10 @@ import (
 	"os"
 	"testing"
 
+	"github.com/h2non/bimg"
 	"github.com/stretchr/testify/assert"
+	"github.com/trilopin/godinary/image"
 	"github.com/trilopin/godinary/storage"
-	bimg "gopkg.in/h2non/bimg.v1"
 )
 
 var fetchCases = []struct {
@@ -88,6 +89,99 @@ func TestFetch(t *testing.T) {
 	}
 }
 
+func TestFetchAutoFormat(t *testing.T) {
+	opts := setupModule()
+	defer os.RemoveAll(opts.FSBase)
+
+	url := "/image/fetch/w_100,f_auto/http://upload.wikimedia.org/wikipedia/commons/0/0c/Scarlett_Johansson_Césars_2014.jpg"
+	cases := []struct {
+		accept      string
+		contentType string
+		message     string
+	}{
+		{"image/avif,image/webp,image/*", "image/avif", "avif accepted"},
+		{"image/webp,image/*", "image/webp", "webp accepted"},
+		{"image/*", "image/jpeg", "webp not accepted"},
+		{"image/webp,image/*", "image/webp", "webp accepted from cache"},
+	}
+	for _, test := range cases {
+		if test.contentType == "image/avif" && !bimg.IsTypeSupportedSave(bimg.AVIF) {
+			// libvips built without libheif falls back to webp
+			test.contentType = "image/webp"
+		}
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Accept", test.accept)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Fetch(opts))
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, 200, rr.Code, test.message)
+		assert.Equal(t, test.contentType, rr.Header().Get("Content-Type"), test.message)
+		assert.Equal(t, "Accept", rr.Header().Get("Vary"), test.message)
+	}
+}
+
+func TestFetchClientHints(t *testing.T) {
+	opts := setupModule()
+	defer os.RemoveAll(opts.FSBase)
+
+	req, _ := http.NewRequest("GET", "/image/fetch/w_auto:100/http://upload.wikimedia.org/wikipedia/commons/0/0c/Scarlett_Johansson_Césars_2014.jpg", nil)
+	req.Header.Set("Sec-CH-Width", "300")
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Fetch(opts))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, 200, rr.Code)
+	assert.Equal(t, acceptCH, rr.Header().Get("Accept-CH"))
+	assert.Equal(t, "Sec-CH-Width, Width, Sec-CH-Viewport-Width, Viewport-Width, DPR, Sec-CH-DPR, Save-Data", rr.Header().Get("Vary"))
+	size, _ := bimg.NewImage(rr.Body.Bytes()).Size()
+	assert.Equal(t, 320, size.Width, "rounded up to breakpoint")
+}
+
+func TestClientDPR(t *testing.T) {
+	cases := []struct {
+		headers     map[string]string
+		expected    float64
+		description string
+	}{
+		{map[string]string{"Sec-CH-DPR": "2"}, 2, "client hint"},
+		{map[string]string{"DPR": "1.5"}, 1.5, "legacy client hint"},
+		{map[string]string{"Sec-CH-DPR": "3", "DPR": "1.5"}, 3, "client hint first"},
+		{map[string]string{"DPR": "fake"}, 0, "not a number"},
+		{map[string]string{"DPR": "NaN"}, 0, "nan"},
+		{map[string]string{"DPR": "-2"}, 0, "negative"},
+		{map[string]string{"Sec-CH-DPR": "+Inf", "DPR": "2"}, 2, "infinite"},
+		{map[string]string{}, 0, "without client hint"},
+	}
+	for _, test := range cases {
+		req, _ := http.NewRequest("GET", "/", nil)
+		for name, value := range test.headers {
+			req.Header.Set(name, value)
+		}
+		assert.Equal(t, test.expected, clientDPR(req), test.description)
+	}
+}
+
+func TestClientHints(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Width", "640")
+	req.Header.Set("Sec-CH-Viewport-Width", "360")
+	req.Header.Set("Viewport-Width", "fake")
+	req.Header.Set("Save-Data", "on")
+	job := image.NewJob()
+	clientHints(job, req)
+	assert.Equal(t, 640, job.ClientWidth)
+	assert.Equal(t, 360, job.ClientViewportWidth)
+	assert.True(t, job.SaveData)
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	job = image.NewJob()
+	clientHints(job, req)
+	assert.Equal(t, 0, job.ClientWidth)
+	assert.Equal(t, 0, job.ClientViewportWidth)
+	assert.False(t, job.SaveData)
+}
+
 func TestFetchWithoutAcceptHeader(t *testing.T) {
 	opts := setupModule()
 	defer os.RemoveAll(opts.FSBase)
@@ -130,3 +224,81 @@ func testDomainFromURL(t *testing.T) {
 		assert.Equal(t, err, test.err)
 	}
 }
+
+var strictCases = []struct {
+	url     string
+	status  int
+	message string
+}{
+	{
+		"/image/fetch/w_100,h_100,c_limit/http://upload.wikimedia.org/wikipedia/commons/0/0c/Scarlett_Johansson_Césars_2014.jpg",
+		403,
+		"Raw filters are not allowed",
+	},
+	{
+		"/image/fetch/t_card,w_100/http://upload.wikimedia.org/wikipedia/commons/0/0c/Scarlett_Johansson_Césars_2014.jpg",
+		403,
+		"Named transformation mixed with raw filters",
+	},
+	{
+		"/image/fetch/t_fake/http://upload.wikimedia.org/wikipedia/commons/0/0c/Scarlett_Johansson_Césars_2014.jpg",
+		400,
+		"Unknown named transformation",
+	},
+	{
+		"/image/fetch/t_card/http://upload.wikimedia.org/wikipedia/commons/0/0c/Scarlett_Johansson_Césars_2014.jpg",
+		200,
+		"Named transformation",
+	},
+	{
+		"/image/fetch/t_card,f_auto/http://upload.wikimedia.org/wikipedia/commons/0/0c/Scarlett_Johansson_Césars_2014.jpg",
+		200,
+		"Named transformation with format",
+	},
+}
+
+func TestFetchStrict(t *testing.T) {
+	opts := setupModule()
+	opts.Strict = true
+	opts.Transformations = map[string]string{"card": "w_100,h_100,c_limit"}
+	defer os.RemoveAll(opts.FSBase)
+
+	for _, test := range strictCases {
+		req, _ := http.NewRequest("GET", test.url, nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Fetch(opts))
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, test.status, rr.Code, test.message)
+	}
+}
+
+func TestFetchSignedURLs(t *testing.T) {
+	opts := setupModule()
+	opts.SignedURLs = true
+	opts.APIAuth = map[string]string{"key": "secret"}
+	defer os.RemoveAll(opts.FSBase)
+
+	urlData := "w_100,h_100,c_limit/http://upload.wikimedia.org/wikipedia/commons/0/0c/Scarlett_Johansson_Césars_2014.jpg"
+	cases := []struct {
+		url     string
+		strict  bool
+		status  int
+		message string
+	}{
+		{"/image/fetch/" + urlData, false, 403, "Unsigned url"},
+		{"/image/fetch/" + SignURL(urlData, "fake"), false, 403, "Bad signature"},
+		{"/image/fetch/" + SignURL(urlData, "secret"), false, 200, "Signed url"},
+		{"/image/fetch/" + SignURL(urlData, "secret"), true, 200, "Signed url in strict mode"},
+		{"/image/fetch/" + urlData, true, 403, "Unsigned url in strict mode"},
+	}
+	for _, test := range cases {
+		opts.Strict = test.strict
+		req, _ := http.NewRequest("GET", test.url, nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Fetch(opts))
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, test.status, rr.Code, test.message)
+	}
+}
@@ -0,0 +1,122 @@
+package image
+
+import (
+	"image"
+	"math"
+)
+
+// improveClip is the fraction of darkest and brightest pixels ignored to
+// find the levels of every channel
+const improveClip = 0.005
+
+// adjust maps the color of every pixel. Colors are given and returned
+// without alpha premultiplication, between 0 and 255.
+func adjust(img *image.RGBA, f func(r, g, b float64) (float64, float64, float64)) {
+	for i := 0; i < len(img.Pix); i += 4 {
+		a := img.Pix[i+3]
+		if a == 0 {
+			continue
+		}
+		k := 255 / float64(a)
+		r, g, b := f(float64(img.Pix[i])*k, float64(img.Pix[i+1])*k, float64(img.Pix[i+2])*k)
+		img.Pix[i] = clamp(r/k, a)
+		img.Pix[i+1] = clamp(g/k, a)
+		img.Pix[i+2] = clamp(b/k, a)
+	}
+}
+
+// brightness scales colors, -99 is almost black and 100 doubles them
+func brightness(img *image.RGBA, strength int) {
+	factor := 1 + float64(strength)/100
+	adjust(img, func(r, g, b float64) (float64, float64, float64) {
+		return r * factor, g * factor, b * factor
+	})
+}
+
+// contrast moves colors away from the middle gray, -100 is flat gray and
+// 100 doubles the distance
+func contrast(img *image.RGBA, strength int) {
+	factor := 1 + float64(strength)/100
+	level := func(v float64) float64 {
+		return (v-128)*factor + 128
+	}
+	adjust(img, func(r, g, b float64) (float64, float64, float64) {
+		return level(r), level(g), level(b)
+	})
+}
+
+// saturation moves colors away from their luma, -100 is grayscale and 100
+// doubles the distance
+func saturation(img *image.RGBA, strength int) {
+	factor := 1 + float64(strength)/100
+	adjust(img, func(r, g, b float64) (float64, float64, float64) {
+		luma := 0.299*r + 0.587*g + 0.114*b
+		return luma + (r-luma)*factor, luma + (g-luma)*factor, luma + (b-luma)*factor
+	})
+}
+
+// gamma corrects colors with exponent 1/(1+strength/100), positive values
+// brighten midtones
+func gamma(img *image.RGBA, strength int) {
+	var table [256]float64
+	exponent := 1 / (1 + float64(strength)/100)
+	for v := range table {
+		table[v] = 255 * math.Pow(float64(v)/255, exponent)
+	}
+	level := func(v float64) float64 {
+		return table[clamp(v, 255)]
+	}
+	adjust(img, func(r, g, b float64) (float64, float64, float64) {
+		return level(r), level(g), level(b)
+	})
+}
+
+// improve stretches levels of every channel to the full range, ignoring
+// outliers. Strength blends the original with the stretched colors.
+func improve(img *image.RGBA, strength int) {
+	var histograms [3][256]int
+	var total int
+	adjust(img, func(r, g, b float64) (float64, float64, float64) {
+		histograms[0][clamp(r, 255)]++
+		histograms[1][clamp(g, 255)]++
+		histograms[2][clamp(b, 255)]++
+		total++
+		return r, g, b
+	})
+	if total == 0 {
+		return
+	}
+
+	var low, high [3]float64
+	for c, histogram := range histograms {
+		low[c], high[c] = levels(histogram, int(float64(total)*improveClip))
+	}
+	amount := float64(strength) / 100
+	stretch := func(v float64, c int) float64 {
+		if high[c] <= low[c] {
+			return v
+		}
+		stretched := (v - low[c]) * 255 / (high[c] - low[c])
+		return v + (stretched-v)*amount
+	}
+	adjust(img, func(r, g, b float64) (float64, float64, float64) {
+		return stretch(r, 0), stretch(g, 1), stretch(b, 2)
+	})
+}
+
+// levels returns the darkest and brightest values of a histogram after
+// skipping clip pixels at both ends
+func levels(histogram [256]int, clip int) (float64, float64) {
+	low, high := 0, 255
+	for sum := 0; low < 255; low++ {
+		if sum += histogram[low]; sum > clip {
+			break
+		}
+	}
+	for sum := 0; high > 0; high-- {
+		if sum += histogram[high]; sum > clip {
+			break
+		}
+	}
+	return float64(low), float64(high)
+}
@@ -0,0 +1,64 @@
+package image
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pixel builds a one pixel image
+func pixel(c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.SetRGBA(0, 0, c)
+	return img
+}
+
+func TestAdjustments(t *testing.T) {
+	cases := []struct {
+		adjustment  func(*image.RGBA, int)
+		strength    int
+		input       color.RGBA
+		expected    color.RGBA
+		description string
+	}{
+		{brightness, 50, color.RGBA{100, 50, 200, 255}, color.RGBA{150, 75, 255, 255}, "brighter"},
+		{brightness, -50, color.RGBA{100, 50, 200, 255}, color.RGBA{50, 25, 100, 255}, "darker"},
+		{brightness, 50, color.RGBA{50, 25, 100, 128}, color.RGBA{75, 38, 128, 128}, "premultiplied alpha"},
+		{contrast, 100, color.RGBA{100, 150, 128, 255}, color.RGBA{72, 172, 128, 255}, "more contrast"},
+		{contrast, -100, color.RGBA{100, 150, 0, 255}, color.RGBA{128, 128, 128, 255}, "flat"},
+		{saturation, -100, color.RGBA{255, 0, 0, 255}, color.RGBA{76, 76, 76, 255}, "grayscale"},
+		{saturation, 0, color.RGBA{255, 0, 0, 255}, color.RGBA{255, 0, 0, 255}, "same saturation"},
+		{gamma, 0, color.RGBA{100, 50, 200, 255}, color.RGBA{100, 50, 200, 255}, "same gamma"},
+		{gamma, 100, color.RGBA{64, 0, 255, 255}, color.RGBA{128, 0, 255, 255}, "brighter midtones"},
+		{sepia, 100, color.RGBA{0, 0, 0, 255}, color.RGBA{0, 0, 0, 255}, "black sepia"},
+	}
+	for _, test := range cases {
+		img := pixel(test.input)
+		test.adjustment(img, test.strength)
+		assert.Equal(t, test.expected, img.RGBAAt(0, 0), test.description)
+	}
+}
+
+func TestImprove(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(0, 0, color.RGBA{50, 50, 50, 255})
+	img.SetRGBA(1, 0, color.RGBA{150, 150, 150, 255})
+
+	improve(img, 100)
+	assert.Equal(t, color.RGBA{0, 0, 0, 255}, img.RGBAAt(0, 0), "darkest is black")
+	assert.Equal(t, color.RGBA{255, 255, 255, 255}, img.RGBAAt(1, 0), "brightest is white")
+
+	flat := pixel(color.RGBA{50, 50, 50, 255})
+	improve(flat, 100)
+	assert.Equal(t, color.RGBA{50, 50, 50, 255}, flat.RGBAAt(0, 0), "flat image is kept")
+}
+
+func TestLevels(t *testing.T) {
+	var histogram [256]int
+	histogram[0], histogram[10], histogram[200], histogram[255] = 1, 100, 100, 1
+	low, high := levels(histogram, 1)
+	assert.Equal(t, 10.0, low, "outliers are skipped")
+	assert.Equal(t, 200.0, high, "outliers are skipped")
+}
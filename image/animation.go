@@ -0,0 +1,148 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+
+	"github.com/h2non/bimg"
+)
+
+// defaultQuality is used by encoders when quality is not given
+const defaultQuality = 75
+
+// limits of decoded animations, every frame is a complete rgba image
+const (
+	maxAnimationFrames = 1000
+	maxAnimationPixels = 50000000
+)
+
+// animation holds the frames of an animated gif. Frames are composed
+// over the logical screen, so every one of them is a complete image.
+type animation struct {
+	frames []*image.RGBA
+	delays []int
+	loop   int
+}
+
+// decodeAnimation reads every frame of a gif applying disposal methods.
+// Animations with too many frames or pixels are rejected.
+func decodeAnimation(buf []byte) (*animation, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("can't decode gif: %v", err)
+	}
+	if len(g.Image) == 0 {
+		return nil, fmt.Errorf("gif without frames")
+	}
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		bounds = g.Image[0].Bounds()
+	}
+	if len(g.Image) > maxAnimationFrames {
+		return nil, fmt.Errorf("animation of %d frames not allowed", len(g.Image))
+	}
+	if pixels := len(g.Image) * bounds.Dx() * bounds.Dy(); pixels > maxAnimationPixels {
+		return nil, fmt.Errorf("animation of %d pixels not allowed", pixels)
+	}
+
+	anim := &animation{loop: g.LoopCount}
+	screen := image.NewRGBA(bounds)
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = clone(screen)
+		}
+		draw.Draw(screen, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		anim.frames = append(anim.frames, clone(screen))
+		anim.delays = append(anim.delays, g.Delay[i])
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(screen, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			screen = previous
+		}
+	}
+	return anim, nil
+}
+
+// clone copies a rgba image
+func clone(img *image.RGBA) *image.RGBA {
+	copied := image.NewRGBA(img.Bounds())
+	copy(copied.Pix, img.Pix)
+	return copied
+}
+
+// png encodes the nth frame, starting at 0, as png
+func (anim *animation) png(n int) ([]byte, error) {
+	return encodePNG(anim.frames[n])
+}
+
+// pngs encodes every frame as png
+func (anim *animation) pngs() ([][]byte, error) {
+	frames := make([][]byte, len(anim.frames))
+	for n := range anim.frames {
+		var err error
+		if frames[n], err = anim.png(n); err != nil {
+			return nil, err
+		}
+	}
+	return frames, nil
+}
+
+// encode builds an animated image from processed png frames, keeping
+// delays and loop count of the original animation
+func (anim *animation) encode(ctx context.Context, frames [][]byte, format bimg.ImageType, quality int) ([]byte, error) {
+	out, err := anim.gif(frames)
+	if err != nil {
+		return nil, err
+	}
+	if format == bimg.WEBP {
+		return animatedWebp(ctx, out, quality)
+	}
+	return out, nil
+}
+
+// gif quantizes frames with palettes built from the processed frames, as
+// steps may add colors missing in the original ones
+func (anim *animation) gif(frames [][]byte) ([]byte, error) {
+	g := &gif.GIF{LoopCount: anim.loop}
+	for n, frame := range frames {
+		img, err := decodeRGBA(frame)
+		if err != nil {
+			return nil, fmt.Errorf("can't decode frame %d: %v", n, err)
+		}
+		paletted := image.NewPaletted(img.Bounds(), quantize(img))
+		draw.FloydSteinberg.Draw(paletted, img.Bounds(), img, img.Bounds().Min)
+		g.Image = append(g.Image, paletted)
+		g.Delay = append(g.Delay, anim.delays[n])
+		// frames are complete images, the previous one must be cleared
+		g.Disposal = append(g.Disposal, gif.DisposalBackground)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		return nil, fmt.Errorf("can't encode gif: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// animatedWebp converts an animated gif to webp. bimg only loads the
+// first frame, so the conversion is done by the vips command line tool.
+func animatedWebp(ctx context.Context, buf []byte, quality int) ([]byte, error) {
+	if quality == 0 {
+		quality = defaultQuality
+	}
+	out, err := vipsCopy(ctx, buf, "input.gif[n=-1]", fmt.Sprintf("output.webp[Q=%d]", quality))
+	if err != nil {
+		return nil, fmt.Errorf("can't convert to animated webp: %v", err)
+	}
+	return out, nil
+}
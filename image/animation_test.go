@@ -0,0 +1,94 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	red         = color.RGBA{R: 255, A: 255}
+	blue        = color.RGBA{B: 255, A: 255}
+	transparent = color.RGBA{}
+)
+
+func TestDecodeAnimation(t *testing.T) {
+	buf, _ := ioutil.ReadFile("testdata/animated.gif")
+	anim, err := decodeAnimation(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(anim.frames))
+	for _, frame := range anim.frames {
+		assert.Equal(t, 120, frame.Bounds().Dx(), "frames cover the screen")
+		assert.Equal(t, 80, frame.Bounds().Dy(), "frames cover the screen")
+	}
+	assert.Equal(t, red, anim.frames[2].RGBAAt(30, 30), "previous frame is kept")
+	assert.Equal(t, blue, anim.frames[2].RGBAAt(55, 30))
+	assert.Equal(t, transparent, anim.frames[3].RGBAAt(55, 30), "background disposal")
+}
+
+func TestDecodeAnimationFail(t *testing.T) {
+	buf, _ := ioutil.ReadFile("testdata/fiveyears.jpg")
+	_, err := decodeAnimation(buf)
+	assert.NotNil(t, err)
+}
+
+func TestDecodeAnimationLimits(t *testing.T) {
+	frame := image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{red})
+	g := &gif.GIF{}
+	for i := 0; i <= maxAnimationFrames; i++ {
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	gif.EncodeAll(&buf, g)
+	_, err := decodeAnimation(buf.Bytes())
+	assert.Equal(t, fmt.Errorf("animation of %d frames not allowed", maxAnimationFrames+1), err)
+
+	g = &gif.GIF{
+		Image:  []*image.Paletted{frame, frame},
+		Delay:  []int{10, 10},
+		Config: image.Config{Width: 5000, Height: 5001, ColorModel: color.Palette{red}},
+	}
+	buf.Reset()
+	gif.EncodeAll(&buf, g)
+	_, err = decodeAnimation(buf.Bytes())
+	assert.Equal(t, fmt.Errorf("animation of %d pixels not allowed", 2*5000*5001), err)
+}
+
+func TestAnimationGIF(t *testing.T) {
+	buf, _ := ioutil.ReadFile("testdata/animated.gif")
+	anim, _ := decodeAnimation(buf)
+	frames, err := anim.pngs()
+	assert.Nil(t, err)
+
+	out, err := anim.gif(frames)
+	assert.Nil(t, err)
+	g, err := gif.DecodeAll(bytes.NewReader(out))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(g.Image))
+	assert.Equal(t, []int{10, 10, 10, 10}, g.Delay)
+	assert.Equal(t, red, g.Image[2].At(30, 30))
+}
+
+func TestAnimationGIFProcessedColors(t *testing.T) {
+	buf, _ := ioutil.ReadFile("testdata/animated.gif")
+	anim, _ := decodeAnimation(buf)
+	green := color.RGBA{G: 200, A: 255}
+	for _, frame := range anim.frames {
+		draw.Draw(frame, image.Rect(0, 0, 10, 10), image.NewUniform(green), image.Point{}, draw.Src)
+	}
+	frames, _ := anim.pngs()
+
+	out, err := anim.gif(frames)
+	assert.Nil(t, err)
+	g, _ := gif.DecodeAll(bytes.NewReader(out))
+	assert.Equal(t, green, g.Image[0].At(5, 5), "color added by steps is kept")
+	assert.Equal(t, red, g.Image[2].At(30, 30))
+}
@@ -0,0 +1,75 @@
+package image
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+// white is the default background for padded images
+var white = bimg.Color{R: 255, G: 255, B: 255}
+
+var namedColors = map[string]bimg.Color{
+	"white":  white,
+	"black":  {R: 0, G: 0, B: 0},
+	"red":    {R: 255, G: 0, B: 0},
+	"green":  {R: 0, G: 128, B: 0},
+	"blue":   {R: 0, G: 0, B: 255},
+	"yellow": {R: 255, G: 255, B: 0},
+	"orange": {R: 255, G: 165, B: 0},
+	"purple": {R: 128, G: 0, B: 128},
+	"gray":   {R: 128, G: 128, B: 128},
+	"grey":   {R: 128, G: 128, B: 128},
+}
+
+// parseColor accepts named colors and hex colors as rgb:ffffff or rgb:fff
+func parseColor(s string) (bimg.Color, error) {
+	if c, ok := namedColors[s]; ok {
+		return c, nil
+	}
+	if !strings.HasPrefix(s, "rgb:") {
+		return white, fmt.Errorf("color \"%s\" not allowed", s)
+	}
+	code := s[4:]
+	if len(code) == 3 {
+		code = string([]byte{code[0], code[0], code[1], code[1], code[2], code[2]})
+	}
+	rgb, err := hex.DecodeString(code)
+	if err != nil || len(rgb) != 3 {
+		return white, fmt.Errorf("color \"%s\" not allowed", s)
+	}
+	return bimg.Color{R: rgb[0], G: rgb[1], B: rgb[2]}, nil
+}
+
+// edgeColor returns the average color of the image borders
+func (img *Image) edgeColor() (bimg.Color, error) {
+	sample, _, err := img.sample(sampleSize)
+	if err != nil {
+		return white, err
+	}
+	var r, g, b, n uint64
+	bounds := sample.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if y != bounds.Min.Y && y != bounds.Max.Y-1 && x != bounds.Min.X && x != bounds.Max.X-1 {
+				continue
+			}
+			cr, cg, cb, _ := sample.At(x, y).RGBA()
+			r, g, b, n = r+uint64(cr>>8), g+uint64(cg>>8), b+uint64(cb>>8), n+1
+		}
+	}
+	if n == 0 {
+		return white, fmt.Errorf("empty image")
+	}
+	return bimg.Color{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n)}, nil
+}
+
+// canvas builds a png of given dimensions filled with a color
+func canvas(width, height int, c bimg.Color) ([]byte, error) {
+	palette := color.Palette{color.RGBA{R: c.R, G: c.G, B: c.B, A: 255}}
+	return encodePNG(image.NewPaletted(image.Rect(0, 0, width, height), palette))
+}
@@ -0,0 +1,43 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"testing"
+
+	"github.com/h2non/bimg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseColor(t *testing.T) {
+	cases := []struct {
+		color       string
+		expected    bimg.Color
+		err         error
+		description string
+	}{
+		{"white", white, nil, "named color"},
+		{"rgb:ff8000", bimg.Color{R: 255, G: 128, B: 0}, nil, "hex color"},
+		{"rgb:f80", bimg.Color{R: 255, G: 136, B: 0}, nil, "short hex color"},
+		{"rgb:ff80", white, errors.New("color \"rgb:ff80\" not allowed"), "bad hex color"},
+		{"rgb:gg0000", white, errors.New("color \"rgb:gg0000\" not allowed"), "not hex color"},
+		{"fake", white, errors.New("color \"fake\" not allowed"), "unknown color"},
+	}
+	for _, test := range cases {
+		c, err := parseColor(test.color)
+		assert.Equal(t, test.err, err, test.description)
+		assert.Equal(t, test.expected, c, test.description)
+	}
+}
+
+func TestCanvas(t *testing.T) {
+	buf, err := canvas(30, 20, bimg.Color{R: 255, G: 0, B: 0})
+	assert.Nil(t, err)
+	img, err := png.Decode(bytes.NewReader(buf))
+	assert.Nil(t, err)
+	assert.Equal(t, 30, img.Bounds().Dx())
+	assert.Equal(t, 20, img.Bounds().Dy())
+	r, g, b, a := img.At(10, 10).RGBA()
+	assert.Equal(t, []uint32{0xffff, 0, 0, 0xffff}, []uint32{r, g, b, a})
+}
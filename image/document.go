@@ -0,0 +1,63 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/h2non/bimg"
+)
+
+// maxDensity limits the resolution used to render documents
+const maxDensity = 600
+
+// maxPagePixels limits the size of rendered pdf pages, density is lowered
+// to fit bigger ones
+const maxPagePixels = 25000000
+
+// isDocument checks if the image type can have several pages
+func isDocument(format bimg.ImageType) bool {
+	return format == bimg.PDF || format == bimg.TIFF
+}
+
+// pageDensity returns the density, in dpi, to render a page of width x
+// height points within maxPagePixels. Density defaults to 72.
+func pageDensity(width, height, density int) int {
+	if density <= 0 {
+		density = 72
+	}
+	pixels := float64(width) * float64(height) * math.Pow(float64(density)/72, 2)
+	if pixels > maxPagePixels {
+		density = int(float64(density) * math.Sqrt(maxPagePixels/pixels))
+	}
+	if density < 1 {
+		density = 1
+	}
+	return density
+}
+
+// renderPage rasterizes a page, starting at 1, of a pdf or tiff as png.
+// Density, in dpi, only applies to pdf and defaults to 72. It is lowered
+// for pages exceeding maxPagePixels.
+func renderPage(ctx context.Context, buf []byte, format bimg.ImageType, page, density int) ([]byte, error) {
+	var input string
+	switch format {
+	case bimg.PDF:
+		input = fmt.Sprintf("input.pdf[page=%d]", page-1)
+		// pages are measured at 72 dpi, a pixel per point
+		width, height, err := vipsSize(ctx, buf, input)
+		if err != nil {
+			return nil, fmt.Errorf("can't render page %d: %v", page, err)
+		}
+		input = fmt.Sprintf("input.pdf[page=%d,dpi=%d]", page-1, pageDensity(width, height, density))
+	case bimg.TIFF:
+		input = fmt.Sprintf("input.tif[page=%d]", page-1)
+	default:
+		return nil, fmt.Errorf("format %s has no pages", bimg.ImageTypes[format])
+	}
+	out, err := vipsCopy(ctx, buf, input, "output.png")
+	if err != nil {
+		return nil, fmt.Errorf("can't render page %d: %v", page, err)
+	}
+	return out, nil
+}
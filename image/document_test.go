@@ -0,0 +1,61 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/h2non/bimg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderPage(t *testing.T) {
+	buf, _ := ioutil.ReadFile("testdata/flyer.pdf")
+	out, err := renderPage(context.Background(), buf, bimg.PDF, 1, 144)
+	assert.Nil(t, err)
+	size, _ := bimg.NewImage(out).Size()
+	assert.Equal(t, 400, size.Width, "200pt at 144dpi")
+	assert.Equal(t, 200, size.Height, "100pt at 144dpi")
+
+	_, err = renderPage(context.Background(), buf, bimg.PDF, 3, 0)
+	assert.NotNil(t, err, "page out of range")
+}
+
+func TestVipsSize(t *testing.T) {
+	buf, _ := ioutil.ReadFile("testdata/flyer.pdf")
+	width, height, err := vipsSize(context.Background(), buf, "input.pdf[page=0]")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, width, "points at 72dpi")
+	assert.Equal(t, 100, height, "points at 72dpi")
+}
+
+func TestPageDensity(t *testing.T) {
+	assert.Equal(t, 72, pageDensity(200, 100, 0), "default density")
+	assert.Equal(t, 300, pageDensity(595, 842, 300), "a4 fits")
+
+	// a0 poster at 600 dpi would be 19866x28083 pixels
+	density := pageDensity(2384, 3370, 600)
+	assert.True(t, density < 600, "density is lowered")
+	pixels := 2384 * 3370 * density * density / (72 * 72)
+	assert.True(t, pixels <= maxPagePixels && pixels > maxPagePixels*98/100, "page fits")
+
+	assert.Equal(t, 1, pageDensity(1000000, 1000000, 72), "at least 1 dpi")
+}
+
+func TestRenderPageFail(t *testing.T) {
+	_, err := renderPage(context.Background(), nil, bimg.JPEG, 1, 0)
+	assert.Equal(t, errors.New("format jpeg has no pages"), err)
+}
+
+func TestWithoutOptions(t *testing.T) {
+	assert.Equal(t, "input.pdf", withoutOptions("input.pdf[page=1,dpi=72]"))
+	assert.Equal(t, "output.png", withoutOptions("output.png"))
+}
+
+func TestVipsCopyCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := vipsCopy(ctx, []byte("%PDF"), "input.pdf", "output.png")
+	assert.NotNil(t, err)
+}
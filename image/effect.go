@@ -0,0 +1,175 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+// Effect is a filter applied over the resized image. Strength meaning
+// depends on the effect.
+type Effect struct {
+	Name     string
+	Strength int
+}
+
+// String returns the effect with its strength as name:strength
+func (effect *Effect) String() string {
+	if effects[effect.Name].max == 0 {
+		return effect.Name
+	}
+	return effect.Name + ":" + strconv.Itoa(effect.Strength)
+}
+
+// effects lists allowed effects with default strength and its limits.
+// Effects without limits do not accept strength. Color adjustments are
+// effects too.
+var effects = map[string]struct{ def, min, max int }{
+	"blur":       {100, 1, 2000},
+	"sharpen":    {100, 1, 2000},
+	"grayscale":  {0, 0, 0},
+	"sepia":      {80, 1, 100},
+	"pixelate":   {5, 1, 200},
+	"brightness": {80, -99, 100},
+	"contrast":   {50, -100, 100},
+	"saturation": {80, -100, 100},
+	"gamma":      {50, -50, 150},
+	"improve":    {100, 1, 100},
+}
+
+// pixelEffects are applied in go over decoded pixels, the rest are done
+// by libvips
+var pixelEffects = map[string]func(*image.RGBA, int){
+	"sepia":      sepia,
+	"pixelate":   pixelate,
+	"brightness": brightness,
+	"contrast":   contrast,
+	"saturation": saturation,
+	"gamma":      gamma,
+	"improve":    improve,
+}
+
+// parseEffect accepts effects as name or name:strength
+func parseEffect(s string) (*Effect, error) {
+	parts := strings.SplitN(s, ":", 2)
+	limits, ok := effects[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("effect \"%s\" not allowed", parts[0])
+	}
+	effect := &Effect{Name: parts[0], Strength: limits.def}
+	if len(parts) == 1 {
+		return effect, nil
+	}
+	strength, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("effect strength is not integer: %v", err)
+	}
+	if strength < limits.min || strength > limits.max {
+		return nil, fmt.Errorf("effect strength %d not allowed for %s", strength, effect.Name)
+	}
+	effect.Strength = strength
+	return effect, nil
+}
+
+// applyEffect applies image effect over a png buffer and encodes it with
+// given format and image quality
+func (img *Image) applyEffect(buf []byte, format bimg.ImageType) ([]byte, error) {
+	effect := img.Effect
+	options := bimg.Options{Type: format, Quality: img.Quality}
+	switch effect.Name {
+	case "blur":
+		options.GaussianBlur = bimg.GaussianBlur{Sigma: float64(effect.Strength) / 20, MinAmpl: 0.2}
+	case "sharpen":
+		options.Sharpen = bimg.Sharpen{Radius: 1, X1: 2, Y2: 10, Y3: 20, M2: 3 * float64(effect.Strength) / 100}
+	case "grayscale":
+		options.Interpretation = bimg.InterpretationBW
+	default:
+		pixels, err := decodeRGBA(buf)
+		if err != nil {
+			return nil, fmt.Errorf("can't apply effect %s: %v", effect.Name, err)
+		}
+		pixelEffects[effect.Name](pixels, effect.Strength)
+		if buf, err = encodePNG(pixels); err != nil {
+			return nil, fmt.Errorf("can't apply effect %s: %v", effect.Name, err)
+		}
+	}
+	return bimg.NewImage(buf).Process(options)
+}
+
+// decodeRGBA decodes a png into a rgba image
+func decodeRGBA(buf []byte) (*image.RGBA, error) {
+	decoded, err := png.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	rgba := image.NewRGBA(decoded.Bounds())
+	draw.Draw(rgba, rgba.Rect, decoded, decoded.Bounds().Min, draw.Src)
+	return rgba, nil
+}
+
+// encodePNG encodes an image as png favouring speed
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// clamp limits a channel value to alpha, colors are alpha premultiplied
+func clamp(v float64, alpha uint8) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > float64(alpha) {
+		return alpha
+	}
+	return uint8(v + 0.5)
+}
+
+// sepia blends the image with its sepia toned version, strength is the
+// percentage of sepia
+func sepia(img *image.RGBA, strength int) {
+	amount := float64(strength) / 100
+	adjust(img, func(r, g, b float64) (float64, float64, float64) {
+		sr := 0.393*r + 0.769*g + 0.189*b
+		sg := 0.349*r + 0.686*g + 0.168*b
+		sb := 0.272*r + 0.534*g + 0.131*b
+		return r + (sr-r)*amount, g + (sg-g)*amount, b + (sb-b)*amount
+	})
+}
+
+// pixelate replaces blocks of size x size pixels by their average color
+func pixelate(img *image.RGBA, size int) {
+	bounds := img.Rect
+	for top := bounds.Min.Y; top < bounds.Max.Y; top += size {
+		for left := bounds.Min.X; left < bounds.Max.X; left += size {
+			block := image.Rect(left, top, left+size, top+size).Intersect(bounds)
+			var sum [4]int
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					i := img.PixOffset(x, y)
+					for c := 0; c < 4; c++ {
+						sum[c] += int(img.Pix[i+c])
+					}
+				}
+			}
+			n := block.Dx() * block.Dy()
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					i := img.PixOffset(x, y)
+					for c := 0; c < 4; c++ {
+						img.Pix[i+c] = uint8(sum[c] / n)
+					}
+				}
+			}
+		}
+	}
+}
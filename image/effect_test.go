@@ -0,0 +1,77 @@
+package image
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"testing"
+
+	"github.com/h2non/bimg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEffect(t *testing.T) {
+	cases := []struct {
+		effect      string
+		expected    *Effect
+		err         error
+		description string
+	}{
+		{"blur", &Effect{"blur", 100}, nil, "default strength"},
+		{"blur:200", &Effect{"blur", 200}, nil, "strength"},
+		{"grayscale", &Effect{"grayscale", 0}, nil, "without strength"},
+		{"pixelate:10", &Effect{"pixelate", 10}, nil, "pixelate"},
+		{"fake", nil, errors.New("effect \"fake\" not allowed"), "not accepted"},
+		{"blur:5000", nil, errors.New("effect strength 5000 not allowed for blur"), "too strong"},
+		{"grayscale:10", nil, errors.New("effect strength 10 not allowed for grayscale"), "strength not accepted"},
+	}
+	for _, test := range cases {
+		effect, err := parseEffect(test.effect)
+		assert.Equal(t, test.err, err, test.description)
+		assert.Equal(t, test.expected, effect, test.description)
+	}
+}
+
+func TestEffectString(t *testing.T) {
+	assert.Equal(t, "blur:100", (&Effect{"blur", 100}).String())
+	assert.Equal(t, "grayscale", (&Effect{"grayscale", 0}).String())
+}
+
+func TestSepia(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.SetRGBA(0, 0, color.RGBA{R: 100, G: 100, B: 100, A: 255})
+	sepia(img, 100)
+	c := img.RGBAAt(0, 0)
+	assert.True(t, c.R > c.G && c.G > c.B, "sepia tones are warm")
+
+	img.SetRGBA(0, 0, color.RGBA{R: 100, G: 100, B: 100, A: 255})
+	sepia(img, 0)
+	assert.Equal(t, color.RGBA{R: 100, G: 100, B: 100, A: 255}, img.RGBAAt(0, 0), "no sepia")
+}
+
+func TestPixelate(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	draw.Draw(img, img.Rect, image.Black, image.Point{}, draw.Src)
+	img.SetRGBA(0, 0, color.RGBA{R: 200, A: 255})
+	pixelate(img, 2)
+	assert.Equal(t, color.RGBA{R: 50, A: 255}, img.RGBAAt(1, 1), "block average")
+	assert.Equal(t, color.RGBA{A: 255}, img.RGBAAt(2, 0), "partial block")
+}
+
+func TestProcessEffect(t *testing.T) {
+	for effect := range effects {
+		source := Image{}
+		r, _ := os.Open("testdata/fiveyears.jpg")
+		source.Load(r)
+		img := Image{Width: 300, Height: 200, Format: bimg.JPEG}
+		img.Effect, _ = parseEffect(effect)
+
+		err := img.Process(source, nil)
+		assert.Nil(t, err, effect)
+		size, _ := bimg.NewImage(img.RawContent).Size()
+		assert.Equal(t, 300, size.Width, effect)
+		assert.Equal(t, "jpeg", bimg.DetermineImageTypeName(img.RawContent), effect)
+	}
+}
@@ -0,0 +1,142 @@
+package image
+
+import (
+	_ "embed"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+
+	pigo "github.com/esimov/pigo/core"
+)
+
+// minFaceQuality discards weak detections from the classifier
+const minFaceQuality = 5.0
+
+// facefinder is the face cascade bundled with pigo (MIT license)
+//
+//go:embed cascade/facefinder
+var facefinder []byte
+
+// faceClassifier is the cascade used to detect faces, nil if not loaded
+var faceClassifier *pigo.Pigo
+
+func init() {
+	if err := unpackFaceCascade(facefinder); err != nil {
+		log.Println(err)
+	}
+}
+
+// LoadFaceCascade reads a pigo cascade file replacing the bundled one
+// used by g_face and g_faces
+func LoadFaceCascade(path string) error {
+	cascade, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("can't read face cascade: %v", err)
+	}
+	return unpackFaceCascade(cascade)
+}
+
+// unpackFaceCascade sets the classifier used to detect faces
+func unpackFaceCascade(cascade []byte) error {
+	classifier, err := pigo.NewPigo().Unpack(cascade)
+	if err != nil {
+		return fmt.Errorf("can't unpack face cascade: %v", err)
+	}
+	faceClassifier = classifier
+	return nil
+}
+
+// faces detects faces in the image, biggest first, in image coordinates
+func (img *Image) faces() ([]Area, error) {
+	if faceClassifier == nil {
+		return nil, fmt.Errorf("face cascade not loaded")
+	}
+	sample, factor, err := img.sample(sampleSize * 2)
+	if err != nil {
+		return nil, err
+	}
+	gray := grayscale(sample)
+	rows, cols := gray.Rect.Dy(), gray.Rect.Dx()
+	params := pigo.CascadeParams{
+		MinSize:     20,
+		MaxSize:     cols,
+		ShiftFactor: 0.1,
+		ScaleFactor: 1.1,
+		ImageParams: pigo.ImageParams{
+			Pixels: gray.Pix,
+			Rows:   rows,
+			Cols:   cols,
+			Dim:    gray.Stride,
+		},
+	}
+	detections := faceClassifier.RunCascade(params, 0.0)
+	detections = faceClassifier.ClusterDetections(detections, 0.2)
+
+	var faces []Area
+	for _, d := range detections {
+		if d.Q < minFaceQuality {
+			continue
+		}
+		faces = append(faces, Area{
+			Left:   int(float64(d.Col-d.Scale/2) * factor),
+			Top:    int(float64(d.Row-d.Scale/2) * factor),
+			Width:  int(float64(d.Scale) * factor),
+			Height: int(float64(d.Scale) * factor),
+		})
+	}
+	sort.Slice(faces, func(i, j int) bool {
+		return faces[i].Width > faces[j].Width
+	})
+	return faces, nil
+}
+
+// faceArea detects faces and places an area around them
+func (img *Image) faceArea(width, height int, all bool) (*Area, error) {
+	faces, err := img.faces()
+	if err != nil {
+		return nil, err
+	}
+	if len(faces) == 0 {
+		return nil, fmt.Errorf("no faces found")
+	}
+	return img.areaAround(faces, width, height, all), nil
+}
+
+// areaAround returns the area of given dimensions centered on the first
+// face or, if all is set, on the box containing every face
+func (img *Image) areaAround(faces []Area, width, height int, all bool) *Area {
+	if !all {
+		faces = faces[:1]
+	}
+	box := faces[0]
+	for _, face := range faces[1:] {
+		box = box.union(face)
+	}
+	area := &Area{
+		Left:   box.Left + box.Width/2 - width/2,
+		Top:    box.Top + box.Height/2 - height/2,
+		Width:  width,
+		Height: height,
+	}
+	return area.fit(img.Width, img.Height)
+}
+
+// union returns the smallest area containing both areas
+func (area Area) union(other Area) Area {
+	left, top := area.Left, area.Top
+	right, bottom := area.Left+area.Width, area.Top+area.Height
+	if other.Left < left {
+		left = other.Left
+	}
+	if other.Top < top {
+		top = other.Top
+	}
+	if other.Left+other.Width > right {
+		right = other.Left + other.Width
+	}
+	if other.Top+other.Height > bottom {
+		bottom = other.Top + other.Height
+	}
+	return Area{Left: left, Top: top, Width: right - left, Height: bottom - top}
+}
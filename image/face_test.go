@@ -0,0 +1,85 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAreaUnion(t *testing.T) {
+	a := Area{Left: 10, Top: 10, Width: 20, Height: 20}
+	b := Area{Left: 50, Top: 0, Width: 10, Height: 10}
+	assert.Equal(t, Area{Left: 10, Top: 0, Width: 50, Height: 30}, a.union(b))
+	assert.Equal(t, a.union(b), b.union(a))
+}
+
+func TestFaceAreaWithoutCascade(t *testing.T) {
+	classifier := faceClassifier
+	faceClassifier = nil
+	defer func() { faceClassifier = classifier }()
+
+	img := Image{}
+	r, _ := os.Open("testdata/fiveyears.jpg")
+	img.Load(r)
+	img.ExtractInfo()
+
+	area, err := img.faceArea(100, 100, false)
+	assert.Nil(t, area)
+	assert.Equal(t, fmt.Errorf("face cascade not loaded"), err)
+}
+
+func TestLoadFaceCascadeFail(t *testing.T) {
+	classifier := faceClassifier
+	err := LoadFaceCascade("testdata/fake.cascade")
+	assert.Equal(t, fmt.Errorf("can't read face cascade: open testdata/fake.cascade: no such file or directory"), err)
+	assert.Equal(t, classifier, faceClassifier, "bundled cascade is kept")
+}
+
+// contains checks that area includes other
+func contains(area *Area, other Area) bool {
+	return area.Left <= other.Left && area.Top <= other.Top &&
+		area.Left+area.Width >= other.Left+other.Width &&
+		area.Top+area.Height >= other.Top+other.Height
+}
+
+func TestAreaAround(t *testing.T) {
+	img := Image{Width: 1000, Height: 500}
+	big := Area{Left: 700, Top: 100, Width: 120, Height: 120}
+	small := Area{Left: 100, Top: 300, Width: 60, Height: 60}
+
+	area := img.areaAround([]Area{big, small}, 200, 200, false)
+	assert.Equal(t, &Area{Left: 660, Top: 60, Width: 200, Height: 200}, area, "centered on the biggest face")
+	assert.False(t, contains(area, small))
+
+	area = img.areaAround([]Area{big, small}, 800, 400, true)
+	assert.True(t, contains(area, big) && contains(area, small), "every face")
+
+	area = img.areaAround([]Area{{Left: 950, Top: 450, Width: 40, Height: 40}}, 200, 200, false)
+	assert.Equal(t, &Area{Left: 800, Top: 300, Width: 200, Height: 200}, area, "kept inside the image")
+}
+
+func TestFaceAreaDetection(t *testing.T) {
+	assert.NotNil(t, faceClassifier, "bundled cascade")
+
+	// portrait on the left of a white 960x400 canvas
+	img := Image{}
+	r, _ := os.Open("testdata/face.jpg")
+	img.Load(r)
+	img.ExtractInfo()
+	faces, err := img.faces()
+	assert.Nil(t, err)
+	if !assert.Len(t, faces, 1, "face detected") {
+		return
+	}
+
+	area, err := img.faceArea(300, 300, false)
+	assert.Nil(t, err)
+	assert.True(t, contains(area, faces[0]), "crop contains the face")
+	assert.True(t, area.Left < 100, "crop is not centered")
+
+	area, err = img.faceArea(300, 300, true)
+	assert.Nil(t, err)
+	assert.True(t, contains(area, faces[0]), "crop contains every face")
+}
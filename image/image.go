@@ -8,10 +8,18 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/h2non/bimg"
 	"github.com/trilopin/godinary/storage"
-	bimg "gopkg.in/h2non/bimg.v1"
 )
 
+// Area is a rectangle inside an image, in pixels
+type Area struct {
+	Left   int
+	Top    int
+	Width  int
+	Height int
+}
+
 // Image contains image attributes
 type Image struct {
 	Width       int
@@ -23,6 +31,19 @@ type Image struct {
 	Hash        string
 	URL         string
 	Format      bimg.ImageType
+	Extract     *Area
+	Embed       *Area
+	Background  bimg.Color
+	Effect      *Effect
+	Radius      int
+	// AutoQuality and MaxBytes are applied by jobs on the final encoding
+	AutoQuality string
+	MaxBytes    int
+	// lossless keeps the result as png, it is encoded later with Format
+	lossless bool
+	// output is the format of the final image when this one is an
+	// intermediate result, Format is used when unknown
+	output bimg.ImageType
 }
 
 // Load charges content from bytestring
@@ -71,6 +92,10 @@ func (img *Image) ExtractInfo() error {
 	}
 	img.Height = size.Height
 	img.Width = size.Width
+	// images are rotated following exif orientation when processed
+	if meta, err := img.Content.Metadata(); err == nil && meta.Orientation >= 5 {
+		img.Width, img.Height = img.Height, img.Width
+	}
 	img.AspectRatio = float32(img.Width) / float32(img.Height)
 	return nil
 }
@@ -78,6 +103,15 @@ func (img *Image) ExtractInfo() error {
 // Process resizes and convert image
 func (img *Image) Process(source Image, sd storage.Driver) error {
 	var err error
+
+	// area is extracted from source before resizing
+	if img.Extract != nil {
+		area := img.Extract
+		if _, err = source.Content.Extract(area.Top, area.Left, area.Width, area.Height); err != nil {
+			return err
+		}
+	}
+
 	options := bimg.Options{
 		Width:   img.Width,
 		Height:  img.Height,
@@ -85,11 +119,63 @@ func (img *Image) Process(source Image, sd storage.Driver) error {
 		Type:    img.Format,
 	}
 
+	// image is resized into embed area and placed over a canvas later,
+	// effects and rounded corners are applied over the result. Every pass
+	// but the last one keeps the image lossless.
+	var passes []func([]byte, bimg.ImageType) ([]byte, error)
+	if img.Embed != nil {
+		options.Width = img.Embed.Width
+		options.Height = img.Embed.Height
+		passes = append(passes, img.embed)
+	}
+	if img.Effect != nil {
+		passes = append(passes, img.applyEffect)
+	}
+	if img.Radius != 0 {
+		passes = append(passes, img.round)
+	}
+	// lossless results are encoded later with image format
+	format := img.Format
+	if img.lossless {
+		format = bimg.PNG
+	}
+	if len(passes) > 0 || img.lossless {
+		options.Type = bimg.PNG
+	}
+
 	if img.RawContent, err = source.Content.Process(options); err != nil {
 		return err
 	}
+	for i, pass := range passes {
+		passFormat := bimg.PNG
+		if i == len(passes)-1 {
+			passFormat = format
+		}
+		if img.RawContent, err = pass(img.RawContent, passFormat); err != nil {
+			return err
+		}
+	}
 	if sd != nil {
 		go sd.Write(img.RawContent, img.Hash, "derived/")
 	}
 	return nil
 }
+
+// embed places buffer over a canvas of image dimensions and background
+func (img *Image) embed(buf []byte, format bimg.ImageType) ([]byte, error) {
+	base, err := canvas(img.Width, img.Height, img.Background)
+	if err != nil {
+		return nil, fmt.Errorf("can't create canvas: %v", err)
+	}
+	options := bimg.Options{
+		Quality: img.Quality,
+		Type:    format,
+		WatermarkImage: bimg.WatermarkImage{
+			Left:    img.Embed.Left,
+			Top:     img.Embed.Top,
+			Buf:     buf,
+			Opacity: 1,
+		},
+	}
+	return bimg.NewImage(base).Process(options)
+}
@@ -5,7 +5,7 @@ import (
 	"os"
 	"testing"
 
-	bimg "gopkg.in/h2non/bimg.v1"
+	"github.com/h2non/bimg"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -28,6 +28,16 @@ func TestExtractInfo(t *testing.T) {
 	assert.Equal(t, img.AspectRatio, float32(1.7216917))
 }
 
+func TestExtractInfoOriented(t *testing.T) {
+	img := Image{}
+	r, _ := os.Open("testdata/orientation.jpg")
+	img.Load(r)
+	err := img.ExtractInfo()
+	assert.Nil(t, err)
+	assert.Equal(t, 20, img.Width, "exif orientation 6 is rotated 90 degrees")
+	assert.Equal(t, 40, img.Height, "exif orientation 6 is rotated 90 degrees")
+}
+
 func TestExtractInfoFail(t *testing.T) {
 	img := Image{}
 	r, _ := os.Open("testdata/corrupted-image.jpg.jpg")
@@ -67,3 +77,37 @@ func TestProcess(t *testing.T) {
 	assert.Equal(t, size.Height, 400)
 	assert.Equal(t, size.Width, 300)
 }
+
+func TestProcessEmbed(t *testing.T) {
+	source := Image{}
+	img := Image{
+		Width:      300,
+		Height:     300,
+		Format:     bimg.JPEG,
+		Embed:      &Area{Left: 0, Top: 63, Width: 300, Height: 174},
+		Background: white,
+	}
+	r, _ := os.Open("testdata/fiveyears.jpg")
+	source.Load(r)
+
+	err := img.Process(source, nil)
+	assert.Nil(t, err)
+	newImage := bimg.NewImage(img.RawContent)
+	size, _ := newImage.Size()
+	assert.Equal(t, 300, size.Height)
+	assert.Equal(t, 300, size.Width)
+}
+
+func TestProcessAvif(t *testing.T) {
+	if !bimg.IsTypeSupportedSave(bimg.AVIF) {
+		t.Skip("libvips built without libheif")
+	}
+	source := Image{}
+	img := Image{Width: 300, Height: 400, Format: bimg.AVIF, Quality: 50}
+	r, _ := os.Open("testdata/fiveyears.jpg")
+	source.Load(r)
+
+	err := img.Process(source, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "avif", bimg.DetermineImageTypeName(img.RawContent))
+}
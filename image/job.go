@@ -1,14 +1,18 @@
 package image
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"math"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 
-	bimg "gopkg.in/h2non/bimg.v1"
+	"github.com/h2non/bimg"
+	"github.com/trilopin/godinary/storage"
 )
 
 // Hasher interface
@@ -27,34 +31,71 @@ func (hasher *Sha256) Hash(s string) string {
 	return hex.EncodeToString(ht.Sum(nil))
 }
 
-// Job manages image transformation
+// Job manages image transformation. Steps are applied in order over
+// source and the result is encoded with target format and quality.
 type Job struct {
-	Source     Image
-	Target     Image
-	Filters    map[string]string
-	AcceptWebp bool
-	Hasher     Hasher
+	Source          Image
+	Target          Image
+	Steps           []*Step
+	AcceptWebp      bool
+	AcceptAvif      bool
+	Hasher          Hasher
+	Transformations map[string]string
+	// Named is set when filters only use named transformations and formats
+	Named bool
+	// Vary lists request headers used to negotiate the response
+	Vary []string
+	// Context of the request, it stops external conversions (background
+	// if nil)
+	Context context.Context
+	// Animated keeps every frame of animated sources (fl_animated)
+	Animated bool
+	// Page selects a single frame or page of the source, starting at 1 (pg_N)
+	Page int
+	// Density is the resolution, in dpi, used to render pdf pages (dn_N)
+	Density int
+	// DPR multiplies target dimensions (dpr_N), dpr_auto uses ClientDPR
+	DPR float64
+	// client hints sent by the browser, used by w_auto and dpr_auto
+	ClientDPR           float64
+	ClientWidth         int
+	ClientViewportWidth int
+	SaveData            bool
+	// Breakpoints are the widths w_auto is rounded to, ascending
+	Breakpoints []int
+	hinted      bool
 }
 
 // NewJob constructs a default empty struct and return a pointer to it
 func NewJob() *Job {
 	var job Job
-	job.Filters = make(map[string]string)
-	job.Filters["crop"] = "scale"
 	job.Target.Format = bimg.JPEG
 	job.Hasher = &Sha256{}
 	return &job
 }
 
-func parseFormat(format string, acceptWebp bool) (bimg.ImageType, error) {
+func parseFormat(format string, acceptWebp bool, acceptAvif bool) (bimg.ImageType, error) {
 	switch format {
 	case "jpg", "jpeg":
 		return bimg.JPEG, nil
 	case "webp":
 		return bimg.WEBP, nil
+	case "avif":
+		if !bimg.IsTypeSupportedSave(bimg.AVIF) {
+			return bimg.JPEG, fmt.Errorf("format \"%s\" not supported", format)
+		}
+		return bimg.AVIF, nil
+	case "heic", "heif":
+		if !bimg.IsTypeSupportedSave(bimg.HEIF) {
+			return bimg.JPEG, fmt.Errorf("format \"%s\" not supported", format)
+		}
+		return bimg.HEIF, nil
 	case "auto":
+		if acceptAvif && bimg.IsTypeSupportedSave(bimg.AVIF) {
+			return bimg.AVIF, nil
+		}
 		if acceptWebp {
-			return bimg.JPEG, nil // WEBP disabled
+			return bimg.WEBP, nil
 		}
 		return bimg.JPEG, nil
 	case "png":
@@ -71,6 +112,11 @@ func parseCrop(crop string) (string, error) {
 		"limit": true,
 		"fit":   true,
 		"scale": true,
+		"fill":  true,
+		"thumb": true,
+		"crop":  true,
+		"pad":   true,
+		"lpad":  true,
 	}
 	if !allowed[crop] {
 		return "scale", fmt.Errorf("crop \"%s\" not allowed", crop)
@@ -78,30 +124,402 @@ func parseCrop(crop string) (string, error) {
 	return crop, nil
 }
 
+// maxRelative limits relative dimensions, 1.0 is the source size
+const maxRelative = 10.0
+
+// relativePattern matches relative dimensions, which always have decimals
+// so w_1.0 is the source width and w_1 is one pixel
+var relativePattern = regexp.MustCompile(`^[0-9]*\.[0-9]+$`)
+
+// DefaultBreakpoints are used to round w_auto when none are configured
+var DefaultBreakpoints = []int{320, 480, 640, 768, 960, 1024, 1280, 1440, 1600, 1920, 2560}
+
+// saveDataQuality is used by clients asking to save data, unless quality
+// is given. q_auto is lowered to eco for them.
+const saveDataQuality = 50
+
+// parseAutoWidth accepts auto and auto:fallback, the width used when the
+// client does not send hints
+func parseAutoWidth(s string) (int, error) {
+	if s == "auto" {
+		return 0, nil
+	}
+	if !strings.HasPrefix(s, "auto:") {
+		return 0, fmt.Errorf("targetWidth %s not allowed", s)
+	}
+	fallback, err := strconv.Atoi(s[5:])
+	if err != nil {
+		return 0, fmt.Errorf("targetWidth fallback is not integer: %v", err)
+	}
+	return fallback, nil
+}
+
+// breakpoint rounds width up to the nearest breakpoint, the biggest one
+// limits it
+func breakpoint(width int, breakpoints []int) int {
+	if len(breakpoints) == 0 {
+		breakpoints = DefaultBreakpoints
+	}
+	for _, b := range breakpoints {
+		if width <= b {
+			return b
+		}
+	}
+	return breakpoints[len(breakpoints)-1]
+}
+
+// applyHints resolves w_auto widths and save data once every filter is
+// parsed, dpr can be given after widths. Widths from hints are physical
+// pixels, so dpr does not apply to them.
+func (job *Job) applyHints() {
+	if !job.hinted {
+		return
+	}
+	// every response varies on save data, or caches would serve full
+	// quality images to clients saving data
+	job.vary("Save-Data")
+	if job.SaveData {
+		if job.Target.AutoQuality != "" {
+			job.Target.AutoQuality = "eco"
+		} else if job.Target.Quality == 0 {
+			job.Target.Quality = saveDataQuality
+		}
+		if job.DPR > 1 {
+			job.DPR = 1
+		}
+	}
+	width := job.ClientWidth
+	if width == 0 && job.ClientViewportWidth > 0 {
+		width = int(math.Ceil(float64(job.ClientViewportWidth) * clampDPR(job.ClientDPR)))
+		if job.SaveData {
+			width = job.ClientViewportWidth
+		}
+	}
+	for _, step := range job.Steps {
+		if !step.AutoWidth {
+			continue
+		}
+		if width == 0 {
+			// fallback width is not physical
+			step.AutoWidth = false
+			continue
+		}
+		step.Target.Width = breakpoint(width, job.Breakpoints)
+	}
+}
+
+// maxDPR limits device pixel ratios
+const maxDPR = 4.0
+
+// parseRelative parses a dimension given as a fraction of the source
+func parseRelative(s string) (float64, error) {
+	relative, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("is not a number: %v", err)
+	}
+	if !relativePattern.MatchString(s) || relative <= 0 || relative > maxRelative {
+		return 0, fmt.Errorf("%s not allowed", s)
+	}
+	return relative, nil
+}
+
+func parseDPR(s string) (float64, error) {
+	dpr, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("dpr is not a number: %v", err)
+	}
+	if dpr <= 0 || dpr > maxDPR {
+		return 0, fmt.Errorf("dpr %s not allowed", s)
+	}
+	return dpr, nil
+}
+
+// clampDPR limits the dpr sent by clients and rounds it to one decimal,
+// so similar devices share derived images. Values which are not finite
+// count as 1.
+func clampDPR(dpr float64) float64 {
+	if math.IsNaN(dpr) || math.IsInf(dpr, 0) || dpr < 1 {
+		return 1
+	}
+	if dpr > maxDPR {
+		return maxDPR
+	}
+	return math.Round(dpr*10) / 10
+}
+
+// parseFlag splits flag name and value, as in maxbytes:50000. Allowed
+// flags are mapped to whether they need a value.
+func parseFlag(flag string) (string, string, error) {
+	allowed := map[string]bool{
+		"animated": false,
+		"maxbytes": true,
+	}
+	name, value := flag, ""
+	if i := strings.Index(flag, ":"); i >= 0 {
+		name, value = flag[:i], flag[i+1:]
+	}
+	needsValue, ok := allowed[name]
+	if !ok || needsValue != (value != "") {
+		return "", "", fmt.Errorf("flag \"%s\" not allowed", flag)
+	}
+	return name, value, nil
+}
+
+func parseGravity(gravity string) (string, error) {
+	allowed := map[string]bool{
+		"center":     true,
+		"north":      true,
+		"south":      true,
+		"east":       true,
+		"west":       true,
+		"north_east": true,
+		"north_west": true,
+		"south_east": true,
+		"south_west": true,
+		"auto":       true,
+		"face":       true,
+		"faces":      true,
+	}
+	if !allowed[gravity] {
+		return "center", fmt.Errorf("gravity \"%s\" not allowed", gravity)
+	}
+	return gravity, nil
+}
+
+// vary registers a request header used to negotiate the response
+func (job *Job) vary(header string) {
+	for _, h := range job.Vary {
+		if h == header {
+			return
+		}
+	}
+	job.Vary = append(job.Vary, header)
+}
+
+// maxNesting limits named transformations referencing other ones
+const maxNesting = 5
+
+// transformation looks up a named transformation. Names are case
+// insensitive, config keys are lowercased when loaded.
+func (job *Job) transformation(name string) (string, bool) {
+	name = strings.ToLower(name)
+	for key, definition := range job.Transformations {
+		if strings.ToLower(key) == name {
+			return definition, true
+		}
+	}
+	return "", false
+}
+
+// expand replaces named transformations (t_name) by their definition
+func (job *Job) expand(s string, depth int) (string, error) {
+	if !strings.Contains(s, "t_") {
+		return s, nil
+	}
+	if depth >= maxNesting {
+		return "", fmt.Errorf("too many nested transformations")
+	}
+	components := strings.Split(s, "/")
+	for i, component := range components {
+		filters := strings.Split(component, ",")
+		for j, filter := range filters {
+			if !strings.HasPrefix(filter, "t_") {
+				continue
+			}
+			definition, ok := job.transformation(filter[2:])
+			if !ok {
+				return "", fmt.Errorf("transformation \"%s\" not found", filter[2:])
+			}
+			expanded, err := job.expand(definition, depth+1)
+			if err != nil {
+				return "", err
+			}
+			filters[j] = expanded
+		}
+		components[i] = strings.Join(filters, ",")
+	}
+	return strings.Join(components, "/"), nil
+}
+
+// named checks that filters only use named transformations and formats,
+// which are the only ones allowed in strict mode
+func named(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, component := range strings.Split(s, "/") {
+		for _, filter := range strings.Split(component, ",") {
+			if !strings.HasPrefix(filter, "t_") && !strings.HasPrefix(filter, "f_") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseFilters creates a step for every component of the chain. Format
+// and quality are not bound to steps, they apply to the final image.
 func (job *Job) parseFilters(s string) error {
+	job.Steps = nil
+	for _, component := range strings.Split(s, "/") {
+		step := newStep()
+		if err := job.parseStep(step, component); err != nil {
+			return err
+		}
+		job.Steps = append(job.Steps, step)
+	}
+	return nil
+}
+
+func (job *Job) parseStep(step *Step, s string) error {
 	var err error
 	filters := strings.Split(s, ",")
 	for _, v := range filters {
-		filter := strings.Split(v, "_")
+		if v == "" {
+			continue
+		}
+		filter := strings.SplitN(v, "_", 2)
+		if len(filter) != 2 {
+			return fmt.Errorf("filter \"%s\" not allowed", v)
+		}
 		switch filter[0] {
 		case "h":
-			if job.Target.Height, err = strconv.Atoi(filter[1]); err != nil {
+			if strings.Contains(filter[1], ".") {
+				if step.RelativeHeight, err = parseRelative(filter[1]); err != nil {
+					return fmt.Errorf("targetHeight %v", err)
+				}
+			} else if step.Target.Height, err = strconv.Atoi(filter[1]); err != nil {
 				return fmt.Errorf("targetHeight is not integer: %v", err)
 			}
 		case "w":
-			if job.Target.Width, err = strconv.Atoi(filter[1]); err != nil {
+			if strings.HasPrefix(filter[1], "auto") {
+				if step.Target.Width, err = parseAutoWidth(filter[1]); err != nil {
+					return err
+				}
+				step.AutoWidth = true
+				job.hinted = true
+				job.vary("Sec-CH-Width")
+				job.vary("Width")
+				job.vary("Sec-CH-Viewport-Width")
+				job.vary("Viewport-Width")
+				// viewport widths are multiplied by the client dpr
+				job.vary("DPR")
+				job.vary("Sec-CH-DPR")
+			} else if strings.Contains(filter[1], ".") {
+				if step.RelativeWidth, err = parseRelative(filter[1]); err != nil {
+					return fmt.Errorf("targetWidth %v", err)
+				}
+			} else if step.Target.Width, err = strconv.Atoi(filter[1]); err != nil {
 				return fmt.Errorf("targetWidth is not integer: %v", err)
 			}
+		case "dpr":
+			if filter[1] == "auto" {
+				job.DPR = clampDPR(job.ClientDPR)
+				job.hinted = true
+				job.vary("DPR")
+				job.vary("Sec-CH-DPR")
+			} else if job.DPR, err = parseDPR(filter[1]); err != nil {
+				return err
+			}
+		case "b":
+			if filter[1] != "auto" {
+				if _, err = parseColor(filter[1]); err != nil {
+					return err
+				}
+			}
+			step.Filters["background"] = filter[1]
+		case "a":
+			if step.Filters["angle"], err = parseAngle(filter[1]); err != nil {
+				return err
+			}
+		case "e":
+			if step.Target.Effect, err = parseEffect(filter[1]); err != nil {
+				return err
+			}
+		case "l":
+			if filter[1] == "" {
+				return fmt.Errorf("layer is empty")
+			}
+			if strings.HasPrefix(filter[1], "text:") {
+				if _, err = parseText(strings.TrimPrefix(filter[1], "text:")); err != nil {
+					return err
+				}
+			}
+			step.Filters["layer"] = filter[1]
+		case "co":
+			if _, err = parseColor(filter[1]); err != nil {
+				return err
+			}
+			step.Filters["color"] = filter[1]
+		case "o":
+			var opacity int
+			if opacity, err = strconv.Atoi(filter[1]); err != nil {
+				return fmt.Errorf("opacity is not integer: %v", err)
+			}
+			if opacity < 0 || opacity > 100 {
+				return fmt.Errorf("opacity %d not allowed", opacity)
+			}
+			step.Filters["opacity"] = filter[1]
+		case "r":
+			if step.Target.Radius, err = parseRadius(filter[1]); err != nil {
+				return err
+			}
+		case "x", "y":
+			if _, err = strconv.Atoi(filter[1]); err != nil {
+				return fmt.Errorf("%s is not integer: %v", filter[0], err)
+			}
+			step.Filters[filter[0]] = filter[1]
 		case "q":
-			if job.Target.Quality, err = strconv.Atoi(filter[1]); err != nil {
+			if strings.HasPrefix(filter[1], "auto") {
+				if job.Target.AutoQuality, err = parseAutoQuality(filter[1]); err != nil {
+					return err
+				}
+			} else if job.Target.Quality, err = strconv.Atoi(filter[1]); err != nil {
 				return fmt.Errorf("quality is not integer: %v", err)
 			}
 		case "f":
-			if job.Target.Format, err = parseFormat(filter[1], job.AcceptWebp); err != nil {
+			if job.Target.Format, err = parseFormat(filter[1], job.AcceptWebp, job.AcceptAvif); err != nil {
+				return err
+			}
+			if filter[1] == "auto" {
+				job.vary("Accept")
+			}
+		case "fl":
+			var flag, value string
+			if flag, value, err = parseFlag(filter[1]); err != nil {
 				return err
 			}
+			switch flag {
+			case "animated":
+				job.Animated = true
+			case "maxbytes":
+				if job.Target.MaxBytes, err = strconv.Atoi(value); err != nil {
+					return fmt.Errorf("maxbytes is not integer: %v", err)
+				}
+				if job.Target.MaxBytes < minMaxBytes {
+					return fmt.Errorf("maxbytes %d not allowed", job.Target.MaxBytes)
+				}
+			}
+		case "pg":
+			if job.Page, err = strconv.Atoi(filter[1]); err != nil {
+				return fmt.Errorf("page is not integer: %v", err)
+			}
+			if job.Page < 1 {
+				return fmt.Errorf("page %d not allowed", job.Page)
+			}
+		case "dn":
+			if job.Density, err = strconv.Atoi(filter[1]); err != nil {
+				return fmt.Errorf("density is not integer: %v", err)
+			}
+			if job.Density < 1 || job.Density > maxDensity {
+				return fmt.Errorf("density %d not allowed", job.Density)
+			}
 		case "c":
-			if job.Filters["crop"], err = parseCrop(filter[1]); err != nil {
+			if step.Filters["crop"], err = parseCrop(filter[1]); err != nil {
+				return err
+			}
+		case "g":
+			if step.Filters["gravity"], err = parseGravity(filter[1]); err != nil {
 				return err
 			}
 		}
@@ -110,27 +528,25 @@ func (job *Job) parseFilters(s string) error {
 }
 
 // parseURL takes url data and returns ImageID (source URL or file),
-// filters and maybe an error
+// filters and maybe an error. Chained filters are separated by slashes.
 func parseURL(s string, isFetch bool) (string, string, error) {
-	var offset int
+	var n int
 	var err error
-	var filters, URL string
+	var URL string
 
+	parts := strings.Split(s, "/")
 	if isFetch {
-		parts := strings.SplitN(s, "/", 2)
-		if parts[0] != "http:" && parts[0] != "https:" {
-			filters = parts[0]
-			offset = len(parts[0]) + 1
+		for n < len(parts)-1 && parts[n] != "http:" && parts[n] != "https:" {
+			n++
 		}
-		URL, err = url.QueryUnescape(s[offset:])
+		URL, err = url.QueryUnescape(strings.Join(parts[n:], "/"))
 	} else {
-		parts := strings.Split(s, "/")
-		URL, err = url.QueryUnescape(parts[len(parts)-1])
-		if len(parts) > 1 && strings.Count(parts[0], "_") > 0 {
-			filters = parts[0]
+		for n < len(parts)-1 && strings.Count(parts[n], "_") > 0 {
+			n++
 		}
+		URL, err = url.QueryUnescape(parts[len(parts)-1])
 	}
-	return filters, URL, err
+	return strings.Join(parts[:n], "/"), URL, err
 }
 
 // Parse creates a Job struct from string
@@ -138,46 +554,241 @@ func (job *Job) Parse(fetchData string, isFetch bool) error {
 	var err error
 	var filters string
 	filters, job.Source.URL, err = parseURL(fetchData, isFetch)
+	job.Named = named(filters)
+	if filters, err = job.expand(filters, 0); err != nil {
+		return err
+	}
 	if err = job.parseFilters(filters); err != nil {
 		return err
 	}
-	job.Target.Hash = job.Hasher.Hash(fetchData + string(job.Target.Format))
+	job.applyHints()
+	// hash parsed filters instead of url data, equivalent urls share
+	// derived images and named transformations changes refresh them
+	job.Target.Hash = job.Hasher.Hash(job.canonical())
 	job.Source.Hash = job.Hasher.Hash(job.Source.URL)
 	return nil
 }
 
-// Crop calculates the best strategy to crop the image
-func (job *Job) Crop() error {
+// canonical returns a normalised representation of the job: ordered
+// filters for every step, final format, quality and source
+func (job *Job) canonical() string {
+	var components []string
+	for _, step := range job.Steps {
+		components = append(components, step.canonical())
+	}
+	if job.Animated {
+		components = append(components, "fl_animated")
+	}
+	if job.Target.MaxBytes > 0 {
+		components = append(components, "fl_maxbytes:"+strconv.Itoa(job.Target.MaxBytes))
+	}
+	if job.Page > 0 {
+		components = append(components, "pg_"+strconv.Itoa(job.Page))
+	}
+	if job.Density > 0 {
+		components = append(components, "dn_"+strconv.Itoa(job.Density))
+	}
+	if job.DPR > 0 && job.DPR != 1 {
+		components = append(components, "dpr_"+strconv.FormatFloat(job.DPR, 'f', -1, 64))
+	}
+	quality := "q_" + strconv.Itoa(job.Target.Quality)
+	if job.Target.AutoQuality != "" {
+		quality = "q_auto:" + job.Target.AutoQuality
+	}
+	components = append(components,
+		"f_"+bimg.ImageTypes[job.Target.Format],
+		quality,
+		job.Source.URL,
+	)
+	return strings.Join(components, "/")
+}
+
+// context returns the request context or background without request
+func (job *Job) context() context.Context {
+	if job.Context == nil {
+		return context.Background()
+	}
+	return job.Context
+}
+
+// Process applies every step over the output of the previous one, the
+// last step is encoded as target. Animations are processed frame by frame.
+func (job *Job) Process(sd storage.Driver) error {
+	if len(job.Steps) == 0 {
+		job.Steps = []*Step{newStep()}
+	}
+	frames, anim, err := job.frames()
+	if err != nil {
+		return err
+	}
+
+	// animations, quality search and size budget encode the final image
+	// once every step is applied
+	lossless := anim != nil || job.Target.AutoQuality != "" || job.Target.MaxBytes > 0
+	if err = job.processFrames(frames, lossless, sd); err != nil {
+		return err
+	}
 
-	// reset dimensions
-	switch job.Filters["crop"] {
-	// Preserve aspect ratio, bigger dimension is selected
-	case "fit":
-		if job.Target.Height > job.Target.Width {
-			job.Target.Width = int(float32(job.Target.Height) * job.Source.AspectRatio)
-		} else {
-			job.Target.Height = int(float32(job.Target.Width) / job.Source.AspectRatio)
+	output := job.Steps[len(job.Steps)-1].Target
+	job.Target.Width = output.Width
+	job.Target.Height = output.Height
+	job.Target.RawContent = frames[0]
+	if lossless {
+		if job.Target.RawContent, err = job.encode(frames, anim); err != nil {
+			return err
 		}
-	// Same as Fit but limiting size to original image
-	case "limit":
-		if job.Target.Height > job.Source.Height || job.Target.Width > job.Source.Width {
-			job.Target.Width = job.Source.Width
-			job.Target.Height = job.Source.Height
-		} else {
-			if job.Target.Height > job.Target.Width {
-				job.Target.Width = int(float32(job.Target.Height) * job.Source.AspectRatio)
-			} else {
-				job.Target.Height = int(float32(job.Target.Width) / job.Source.AspectRatio)
+	}
+	if sd != nil {
+		go sd.Write(job.Target.RawContent, job.Target.Hash, "derived/")
+	}
+	return nil
+}
+
+// encode applies target format and quality to lossless frames. q_auto
+// searches quality over the first frame and fl_maxbytes lowers it and then
+// dimensions until the result fits.
+func (job *Job) encode(frames [][]byte, anim *animation) ([]byte, error) {
+	target := &job.Target
+	encode := func(frames [][]byte, quality int) ([]byte, error) {
+		if anim != nil {
+			return anim.encode(job.context(), frames, target.Format, quality)
+		}
+		return bimg.NewImage(frames[0]).Process(bimg.Options{Type: target.Format, Quality: quality})
+	}
+
+	var err error
+	quality := target.Quality
+	if target.AutoQuality != "" && autoQualityFormats[target.Format] {
+		if quality, err = target.autoQuality(frames[0]); err != nil {
+			return nil, err
+		}
+	}
+	buf, err := encode(frames, quality)
+	if err != nil {
+		return nil, err
+	}
+	if target.MaxBytes > 0 && len(buf) > target.MaxBytes {
+		return target.fit(frames, quality, encode)
+	}
+	return buf, nil
+}
+
+// frames returns the images to process: the selected page, every frame
+// if animation is kept or just the source. Animation is returned only
+// when more than one frame is processed.
+func (job *Job) frames() ([][]byte, *animation, error) {
+	buf := job.Source.Content.Image()
+	sourceType := bimg.DetermineImageType(buf)
+	isGIF := sourceType == bimg.GIF
+	animatedFormat := job.Target.Format == bimg.GIF || job.Target.Format == bimg.WEBP
+
+	// svgs are rasterized at the size of the first step, except for crops
+	// which extract regions in source pixels
+	if bimg.IsSVGImage(buf) {
+		first := job.Steps[0]
+		width, height := first.Target.Width, first.Target.Height
+		if job.DPR > 0 {
+			width = int(math.Round(float64(width) * job.DPR))
+			height = int(math.Round(float64(height) * job.DPR))
+		}
+		if first.Filters["crop"] == "crop" {
+			width, height = 0, 0
+		}
+		frame, err := renderSVG(job.context(), buf, width, height, job.Density)
+		return [][]byte{frame}, nil, err
+	}
+
+	// pdfs are always rendered to apply density, tiffs only to pick a page
+	if sourceType == bimg.PDF || (isDocument(sourceType) && job.Page > 0) {
+		page := job.Page
+		if page == 0 {
+			page = 1
+		}
+		frame, err := renderPage(job.context(), buf, sourceType, page, job.Density)
+		return [][]byte{frame}, nil, err
+	}
+
+	if job.Page > 0 {
+		if !isGIF {
+			if job.Page > 1 {
+				return nil, nil, fmt.Errorf("page %d not found", job.Page)
 			}
+			return [][]byte{buf}, nil, nil
+		}
+		anim, err := decodeAnimation(buf)
+		if err != nil {
+			return nil, nil, err
 		}
-	// do not preserve nothing, respect callers decision
-	case "scale":
-		if job.Target.Width == 0 {
-			job.Target.Width = job.Target.Height
+		if job.Page > len(anim.frames) {
+			return nil, nil, fmt.Errorf("page %d not found", job.Page)
 		}
-		if job.Target.Height == 0 {
-			job.Target.Height = job.Target.Width
+		frame, err := anim.png(job.Page - 1)
+		return [][]byte{frame}, nil, err
+	}
+
+	if !job.Animated || !isGIF || !animatedFormat {
+		return [][]byte{buf}, nil, nil
+	}
+	anim, err := decodeAnimation(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(anim.frames) < 2 {
+		return [][]byte{buf}, nil, nil
+	}
+	frames, err := anim.pngs()
+	return frames, anim, err
+}
+
+// processFrames applies every step over every frame, replacing them by
+// the results. Crops are computed on the first frame so all frames share
+// the same geometry. Layer steps compose an overlay over the frames.
+// Lossless keeps the last results as png for a later encoding.
+func (job *Job) processFrames(frames [][]byte, lossless bool, sd storage.Driver) error {
+	for i, step := range job.Steps {
+		// intermediate results are kept lossless, rounded corners are
+		// flattened following the final format
+		step.Target.Format = bimg.PNG
+		step.Target.output = job.Target.Format
+		if i == len(job.Steps)-1 {
+			step.Target.Format = job.Target.Format
+			step.Target.Quality = job.Target.Quality
+			step.Target.lossless = lossless
+		}
+
+		if _, ok := step.Filters["layer"]; ok {
+			layer, err := job.layer(step, sd)
+			if err != nil {
+				return err
+			}
+			if err = step.overlay(frames, layer, job.DPR); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := step.rotate(frames); err != nil {
+			return err
+		}
+		for n := range frames {
+			source := Image{Content: bimg.NewImage(frames[n])}
+			if n == 0 {
+				step.Source = source
+				if err := step.Source.ExtractInfo(); err != nil {
+					return err
+				}
+				step.resolve(job.DPR)
+				if err := step.Crop(); err != nil {
+					return err
+				}
+			}
+			target := step.Target
+			if err := target.Process(source, nil); err != nil {
+				return err
+			}
+			frames[n] = target.RawContent
 		}
+		step.Target.RawContent = frames[0]
 	}
 	return nil
 }
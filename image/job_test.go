@@ -1,11 +1,17 @@
 package image
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"image/gif"
+	"math"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
 
-	bimg "gopkg.in/h2non/bimg.v1"
+	"github.com/h2non/bimg"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -43,14 +49,19 @@ func TestParse(t *testing.T) {
 					Hash: "",
 				},
 				Target: Image{
-					Width:   400,
-					Height:  600,
 					Quality: 65,
 					Format:  bimg.JPEG,
 					Hash:    "",
 				},
-				Filters: map[string]string{"crop": "limit"},
-				Hasher:  fakeHasher,
+				Steps: []*Step{
+					{
+						Target:  Image{Width: 400, Height: 600},
+						Filters: map[string]string{"crop": "limit"},
+					},
+				},
+				Hasher: fakeHasher,
+				Named:  false,
+				Vary:   []string{"Accept"},
 			},
 			"multiple filters",
 		},
@@ -79,12 +90,15 @@ func TestParseURL(t *testing.T) {
 		{"w_400/" + testURL, true, testURL, "w_400", "fetch with multiple filter"},
 		{"w_400,c_limit,h_600,f_jpg/" + testSecureURL, true, testSecureURL, "w_400,c_limit,h_600,f_jpg", "fetch secure with filter"},
 		{"w_400,c_limit,h_600,f_jpg/" + testSecureURL, true, testSecureURL, "w_400,c_limit,h_600,f_jpg", "fetch secure with multiple filter"},
+		{"w_400,c_fill/w_100/" + testURL, true, testURL, "w_400,c_fill/w_100", "fetch with chained filters"},
+		{"w_400/" + url.QueryEscape(testURL), true, testURL, "w_400", "fetch with escaped url"},
 		// upload cases
 		{"file.jpg", false, "file.jpg", "", "upload without filters"},
 		{"folder/file.jpg", false, "file.jpg", "", "upload without filters and folder"},
 		{"w_400/file.jpg", false, "file.jpg", "w_400", "upload with one filters"},
 		{"w_400,c_limit,h_600,f_jpeg/file.jpg", false, "file.jpg", "w_400,c_limit,h_600,f_jpeg", "upload with multiple filters"},
 		{"w_400,c_limit,h_600,f_jpeg/folder/file.jpg", false, "file.jpg", "w_400,c_limit,h_600,f_jpeg", "upload with multiple filters and folder"},
+		{"w_400,c_fill/w_100/folder/file.jpg", false, "file.jpg", "w_400,c_fill/w_100", "upload with chained filters and folder"},
 	}
 	for _, test := range cases {
 		filters, image, err := parseURL(test.input, test.isFetch)
@@ -103,56 +117,110 @@ func TestParseFilters(t *testing.T) {
 	}{
 		{
 			"w_400",
-			&Job{Target: Image{Width: 400, Format: bimg.JPEG}, Filters: map[string]string{"crop": "scale"}},
+			&Job{Target: Image{Format: bimg.JPEG}, Steps: []*Step{{Target: Image{Width: 400}, Filters: map[string]string{"crop": "scale"}}}},
 			nil, "only width",
 		},
 		{
 			"h_400,w_400",
-			&Job{Target: Image{Height: 400, Width: 400, Format: bimg.JPEG}, Filters: map[string]string{"crop": "scale"}},
+			&Job{Target: Image{Format: bimg.JPEG}, Steps: []*Step{{Target: Image{Height: 400, Width: 400}, Filters: map[string]string{"crop": "scale"}}}},
 			nil, "width - height",
 		},
 		{
 			"f_png",
-			&Job{Target: Image{Format: bimg.PNG}, Filters: map[string]string{"crop": "scale"}},
+			&Job{Target: Image{Format: bimg.PNG}, Steps: []*Step{{Target: Image{}, Filters: map[string]string{"crop": "scale"}}}},
 			nil, "png format",
 		},
 		{
 			"f_gif",
-			&Job{Target: Image{Format: bimg.GIF}, Filters: map[string]string{"crop": "scale"}},
+			&Job{Target: Image{Format: bimg.GIF}, Steps: []*Step{{Target: Image{}, Filters: map[string]string{"crop": "scale"}}}},
 			nil, "gif format",
 		},
 		{
 			"f_webp",
-			&Job{Target: Image{Format: bimg.WEBP}, Filters: map[string]string{"crop": "scale"}},
+			&Job{Target: Image{Format: bimg.WEBP}, Steps: []*Step{{Target: Image{}, Filters: map[string]string{"crop": "scale"}}}},
 			nil, "gif format",
 		},
 		{
 			"h_400",
-			&Job{Target: Image{Height: 400, Format: bimg.JPEG}, Filters: map[string]string{"crop": "scale"}},
+			&Job{Target: Image{Format: bimg.JPEG}, Steps: []*Step{{Target: Image{Height: 400}, Filters: map[string]string{"crop": "scale"}}}},
 			nil, "only height",
 		},
 		{
 			"c_limit",
-			&Job{Target: Image{Format: bimg.JPEG}, Filters: map[string]string{"crop": "limit"}},
+			&Job{Target: Image{Format: bimg.JPEG}, Steps: []*Step{{Target: Image{}, Filters: map[string]string{"crop": "limit"}}}},
 			nil, "only crop",
 		},
 		{
 			"h_400,w_400,f_png,q_55,c_limit",
-			&Job{Target: Image{Height: 400, Width: 400, Format: bimg.PNG, Quality: 55}, Filters: map[string]string{"crop": "limit"}},
+			&Job{Target: Image{Format: bimg.PNG, Quality: 55}, Steps: []*Step{{Target: Image{Height: 400, Width: 400}, Filters: map[string]string{"crop": "limit"}}}},
 			nil, "all filters",
 		},
+		{
+			"w_400,h_300,c_fill,g_north_east",
+			&Job{Target: Image{Format: bimg.JPEG}, Steps: []*Step{{Target: Image{Height: 300, Width: 400}, Filters: map[string]string{"crop": "fill", "gravity": "north_east"}}}},
+			nil, "fill with gravity",
+		},
+		{
+			"c_crop,x_10,y_20,w_100,h_50",
+			&Job{Target: Image{Format: bimg.JPEG}, Steps: []*Step{{Target: Image{Height: 50, Width: 100}, Filters: map[string]string{"crop": "crop", "x": "10", "y": "20"}}}},
+			nil, "crop region",
+		},
+		{
+			"w_400,h_300,c_pad,b_rgb:ff0000",
+			&Job{Target: Image{Format: bimg.JPEG}, Steps: []*Step{{Target: Image{Height: 300, Width: 400}, Filters: map[string]string{"crop": "pad", "background": "rgb:ff0000"}}}},
+			nil, "pad with background",
+		},
+		{
+			"c_lpad,b_auto",
+			&Job{Target: Image{Format: bimg.JPEG}, Steps: []*Step{{Target: Image{}, Filters: map[string]string{"crop": "lpad", "background": "auto"}}}},
+			nil, "pad with auto background",
+		},
+		{
+			"w_500,h_500,c_fill/w_100,f_png",
+			&Job{Target: Image{Format: bimg.PNG}, Steps: []*Step{
+				{Target: Image{Height: 500, Width: 500}, Filters: map[string]string{"crop": "fill"}},
+				{Target: Image{Width: 100}, Filters: map[string]string{"crop": "scale"}},
+			}},
+			nil, "chained filters",
+		},
+		{
+			"w_400,e_blur:200/e_grayscale",
+			&Job{Target: Image{Format: bimg.JPEG}, Steps: []*Step{
+				{Target: Image{Width: 400, Effect: &Effect{"blur", 200}}, Filters: map[string]string{"crop": "scale"}},
+				{Target: Image{Effect: &Effect{"grayscale", 0}}, Filters: map[string]string{"crop": "scale"}},
+			}},
+			nil, "effects",
+		},
+		{
+			"w_400/l_logo.png,w_100,o_50,g_south_east,x_10,y_10",
+			&Job{Target: Image{Format: bimg.JPEG}, Steps: []*Step{
+				{Target: Image{Width: 400}, Filters: map[string]string{"crop": "scale"}},
+				{Target: Image{Width: 100}, Filters: map[string]string{
+					"crop": "scale", "layer": "logo.png", "opacity": "50", "gravity": "south_east", "x": "10", "y": "10",
+				}},
+			}},
+			nil, "layer",
+		},
+		{
+			"w_0.5,h_0.25,c_fill",
+			&Job{Target: Image{Format: bimg.JPEG}, Steps: []*Step{
+				{Filters: map[string]string{"crop": "fill"}, RelativeWidth: 0.5, RelativeHeight: 0.25},
+			}},
+			nil, "relative dimensions",
+		},
 		{"c_fake", nil, errors.New("crop \"fake\" not allowed"), "Crop  not accepted"},
+		{"w_100/c_fake", nil, errors.New("crop \"fake\" not allowed"), "Crop in chain not accepted"},
+		{"b_fake", nil, errors.New("color \"fake\" not allowed"), "Background not accepted"},
+		{"g_fake", nil, errors.New("gravity \"fake\" not allowed"), "Gravity not accepted"},
 	}
 	for _, test := range cases {
 		job := NewJob()
 		err := job.parseFilters(test.input)
 		assert.Equal(t, test.err, err, test.description)
 		if err == nil {
-			assert.Equal(t, test.expected.Target.Height, job.Target.Height, test.description)
-			assert.Equal(t, test.expected.Target.Width, job.Target.Width, test.description)
 			assert.Equal(t, test.expected.Target.Format, job.Target.Format, test.description)
 			assert.Equal(t, test.expected.Target.Quality, job.Target.Quality, test.description)
-			assert.Equal(t, test.expected.Filters, job.Filters, test.description)
+			assert.Equal(t, test.expected.Steps, job.Steps, test.description)
 		} else {
 			t.Log(err)
 		}
@@ -169,6 +237,11 @@ func TestParseCrop(t *testing.T) {
 		{"scale", "scale", nil, "Scale"},
 		{"limit", "limit", nil, "Limit"},
 		{"fit", "fit", nil, "Fit"},
+		{"fill", "fill", nil, "Fill"},
+		{"thumb", "thumb", nil, "Thumb"},
+		{"crop", "crop", nil, "Crop"},
+		{"pad", "pad", nil, "Pad"},
+		{"lpad", "lpad", nil, "Lpad"},
 		{"fake", "scale", errors.New("crop \"fake\" not allowed"), "Error case not accepted"},
 	}
 	for _, test := range cases {
@@ -178,24 +251,56 @@ func TestParseCrop(t *testing.T) {
 	}
 }
 
+func TestParseGravity(t *testing.T) {
+	cases := []struct {
+		gravity     string
+		expected    string
+		err         error
+		description string
+	}{
+		{"center", "center", nil, "Center"},
+		{"north", "north", nil, "North"},
+		{"south_west", "south_west", nil, "South west"},
+		{"auto", "auto", nil, "Auto"},
+		{"face", "face", nil, "Face"},
+		{"faces", "faces", nil, "Faces"},
+		{"north_center", "center", errors.New("gravity \"north_center\" not allowed"), "Error case not accepted"},
+	}
+	for _, test := range cases {
+		gravity, err := parseGravity(test.gravity)
+		assert.Equal(t, test.err, err, test.description)
+		assert.Equal(t, test.expected, gravity, test.description)
+	}
+}
+
 func TestParseFormat(t *testing.T) {
 	cases := []struct {
 		format      string
 		webp        bool
+		avif        bool
 		expected    bimg.ImageType
 		err         error
 		description string
 	}{
-		{"jpg", false, bimg.JPEG, nil, "jpg format"},
-		{"jpeg", false, bimg.JPEG, nil, "jpeg format"},
-		{"png", false, bimg.PNG, nil, "png format"},
-		{"gif", false, bimg.GIF, nil, "gif format"},
-		{"auto", false, bimg.JPEG, nil, "auto without webp"},
-		// {"auto", true, bimg.WEBP, nil, "auto with webp"},
-		{"fake", false, bimg.JPEG, errors.New("format \"fake\" not allowed"), "Error case not accepted"},
+		{"jpg", false, false, bimg.JPEG, nil, "jpg format"},
+		{"jpeg", false, false, bimg.JPEG, nil, "jpeg format"},
+		{"png", false, false, bimg.PNG, nil, "png format"},
+		{"gif", false, false, bimg.GIF, nil, "gif format"},
+		{"avif", false, false, bimg.AVIF, nil, "avif format"},
+		{"heic", false, false, bimg.HEIF, nil, "heic format"},
+		{"heif", false, false, bimg.HEIF, nil, "heif format"},
+		{"auto", false, false, bimg.JPEG, nil, "auto without webp"},
+		{"auto", true, false, bimg.WEBP, nil, "auto with webp"},
+		{"auto", true, true, bimg.AVIF, nil, "auto with webp and avif"},
+		{"auto", false, true, bimg.AVIF, nil, "auto with avif"},
+		{"fake", false, false, bimg.JPEG, errors.New("format \"fake\" not allowed"), "Error case not accepted"},
 	}
 	for _, test := range cases {
-		format, err := parseFormat(test.format, test.webp)
+		if (test.expected == bimg.AVIF || test.expected == bimg.HEIF) && !bimg.IsTypeSupportedSave(test.expected) {
+			// libvips built without libheif
+			continue
+		}
+		format, err := parseFormat(test.format, test.webp, test.avif)
 		assert.Equal(t, test.err, err, test.description)
 		assert.Equal(t, test.expected, format, test.description)
 	}
@@ -231,6 +336,243 @@ var parserErrorCases = []struct {
 		fmt.Errorf("quality is not integer: strconv.Atoi: parsing \"fake\": invalid syntax"),
 		"Quality is not an integer",
 	},
+	{
+		"w_100,q_auto:ultra/" + testURL,
+		errors.New("quality auto:ultra not allowed"),
+		"Auto quality level not allowed",
+	},
+	{
+		"t_fake/" + testURL,
+		errors.New("transformation \"fake\" not found"),
+		"Named transformation not found",
+	},
+	{
+		"w_100,h_100,c_crop,x_fake/" + testURL,
+		fmt.Errorf("x is not integer: strconv.Atoi: parsing \"fake\": invalid syntax"),
+		"X is not an integer",
+	},
+	{
+		"w_100,a_fake/" + testURL,
+		errors.New("angle \"fake\" not allowed"),
+		"Angle is not allowed",
+	},
+	{
+		"w_100,e_blur:fake/" + testURL,
+		fmt.Errorf("effect strength is not integer: strconv.Atoi: parsing \"fake\": invalid syntax"),
+		"Effect strength is not integer",
+	},
+	{
+		"w_100,o_200/" + testURL,
+		errors.New("opacity 200 not allowed"),
+		"Opacity too big",
+	},
+	{
+		"l_text:comic_40:Hello/" + testURL,
+		errors.New("font \"comic\" not allowed"),
+		"Font is not bundled",
+	},
+	{
+		"l_text:go_40:Hello,co_fake/" + testURL,
+		errors.New("color \"fake\" not allowed"),
+		"Text color is not allowed",
+	},
+	{
+		"w_100,r_fake/" + testURL,
+		errors.New("radius \"fake\" not allowed"),
+		"Radius is not allowed",
+	},
+	{
+		"w_0.0/" + testURL,
+		errors.New("targetWidth 0.0 not allowed"),
+		"Relative width is zero",
+	},
+	{
+		"w_100/e/" + testURL,
+		errors.New("filter \"e\" not allowed"),
+		"Filter without value",
+	},
+	{
+		"w_100,a/" + testURL,
+		errors.New("filter \"a\" not allowed"),
+		"Filter without value in step",
+	},
+	{
+		"w_2./" + testURL,
+		errors.New("targetWidth 2. not allowed"),
+		"Relative width without decimals",
+	},
+	{
+		"w_100.0/" + testURL,
+		errors.New("targetWidth 100.0 not allowed"),
+		"Relative width too big",
+	},
+	{
+		"h_0.x/" + testURL,
+		fmt.Errorf("targetHeight is not a number: strconv.ParseFloat: parsing \"0.x\": invalid syntax"),
+		"Relative height is not a number",
+	},
+	{
+		"w_100,dpr_5/" + testURL,
+		errors.New("dpr 5 not allowed"),
+		"Dpr too big",
+	},
+	{
+		"w_100,dpr_fake/" + testURL,
+		fmt.Errorf("dpr is not a number: strconv.ParseFloat: parsing \"fake\": invalid syntax"),
+		"Dpr is not a number",
+	},
+	{
+		"w_100,fl_fake/" + testURL,
+		errors.New("flag \"fake\" not allowed"),
+		"Flag is not allowed",
+	},
+	{
+		"w_100,fl_animated:1/" + testURL,
+		errors.New("flag \"animated:1\" not allowed"),
+		"Flag without value",
+	},
+	{
+		"w_100,fl_maxbytes/" + testURL,
+		errors.New("flag \"maxbytes\" not allowed"),
+		"Flag needs value",
+	},
+	{
+		"w_100,fl_maxbytes:fake/" + testURL,
+		fmt.Errorf("maxbytes is not integer: strconv.Atoi: parsing \"fake\": invalid syntax"),
+		"Max bytes is not an integer",
+	},
+	{
+		"w_100,fl_maxbytes:100/" + testURL,
+		errors.New("maxbytes 100 not allowed"),
+		"Max bytes too small",
+	},
+	{
+		"w_100,pg_fake/" + testURL,
+		fmt.Errorf("page is not integer: strconv.Atoi: parsing \"fake\": invalid syntax"),
+		"Page is not an integer",
+	},
+	{
+		"w_100,pg_0/" + testURL,
+		errors.New("page 0 not allowed"),
+		"Page starts at 1",
+	},
+	{
+		"w_100,dn_fake/" + testURL,
+		fmt.Errorf("density is not integer: strconv.Atoi: parsing \"fake\": invalid syntax"),
+		"Density is not an integer",
+	},
+	{
+		"w_100,dn_1000/" + testURL,
+		errors.New("density 1000 not allowed"),
+		"Density too big",
+	},
+}
+
+func TestExpand(t *testing.T) {
+	cases := []struct {
+		input       string
+		expected    string
+		err         error
+		description string
+	}{
+		{"w_100,c_fill", "w_100,c_fill", nil, "without named transformations"},
+		{"t_card", "w_400,h_300,c_fill,q_80", nil, "named transformation"},
+		{"t_card,q_90", "w_400,h_300,c_fill,q_80,q_90", nil, "named transformation with overrides"},
+		{"t_blurred/t_card", "w_1000/w_400,h_300,c_fill,q_80", nil, "chained named transformations"},
+		{"t_nested", "w_1000/w_100", nil, "nested named transformation"},
+		{"t_loop", "", errors.New("too many nested transformations"), "loop"},
+		{"w_100/t_fake", "", errors.New("transformation \"fake\" not found"), "unknown transformation"},
+		{"t_CARD", "w_400,h_300,c_fill,q_80", nil, "case insensitive name"},
+		{"t_promo", "w_200", nil, "case insensitive key"},
+	}
+	job := NewJob()
+	job.Transformations = map[string]string{
+		"card":    "w_400,h_300,c_fill,q_80",
+		"blurred": "w_1000",
+		"nested":  "t_blurred/w_100",
+		"loop":    "t_loop",
+		"Promo":   "w_200",
+	}
+	for _, test := range cases {
+		expanded, err := job.expand(test.input, 0)
+		assert.Equal(t, test.err, err, test.description)
+		assert.Equal(t, test.expected, expanded, test.description)
+	}
+}
+
+func TestNamed(t *testing.T) {
+	cases := []struct {
+		input       string
+		expected    bool
+		description string
+	}{
+		{"", true, "without filters"},
+		{"t_card", true, "named transformation"},
+		{"t_card,f_auto", true, "named transformation and format"},
+		{"t_card/t_blurred", true, "chained named transformations"},
+		{"f_webp", true, "only format"},
+		{"t_card,w_100", false, "named transformation and width"},
+		{"t_card/w_100", false, "chained named transformation and width"},
+		{"w_100,h_100", false, "raw filters"},
+	}
+	for _, test := range cases {
+		assert.Equal(t, test.expected, named(test.input), test.description)
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	cases := []struct {
+		a           string
+		b           string
+		same        bool
+		description string
+	}{
+		{"w_100,h_50/" + testURL, "h_50,w_100/" + testURL, true, "filters order"},
+		{"w_100/" + testURL, "w_100,c_scale/" + testURL, true, "default crop"},
+		{"w_100,f_jpg/" + testURL, "w_100,f_jpeg/" + testURL, true, "format alias"},
+		{"w_100,f_auto/" + testURL, "w_100/" + testURL, true, "auto format without webp"},
+		{"w_100,f_auto/" + testURL, "w_100,f_webp/" + testURL, false, "auto format without webp and webp"},
+		{"w_100,f_avif/" + testURL, "w_100,f_webp/" + testURL, false, "avif and webp"},
+		{"w_100,q_80/" + testURL, "q_80/w_100/" + testURL, false, "steps"},
+		{"w_100/" + testURL, "w_100,fl_animated/" + testURL, false, "animated"},
+		{"w_100/" + testURL, "w_100,fl_maxbytes:5000/" + testURL, false, "max bytes"},
+		{"w_100,pg_1/" + testURL, "w_100,pg_2/" + testURL, false, "page"},
+		{"w_100,pg_1/" + testURL, "w_100,pg_1,dn_150/" + testURL, false, "density"},
+		{"w_100,a_-90/" + testURL, "w_100,a_270/" + testURL, true, "normalised angle"},
+		{"w_100,e_blur/" + testURL, "w_100,e_blur:100/" + testURL, true, "default effect strength"},
+		{"w_100,e_blur/" + testURL, "w_100,e_sepia/" + testURL, false, "effect"},
+		{"e_improve/" + testURL, "e_improve:100/" + testURL, true, "default improve"},
+		{"w_100,r_20/" + testURL, "w_100,r_max/" + testURL, false, "radius"},
+		{"w_100,dpr_1.0/" + testURL, "w_100/" + testURL, true, "default dpr"},
+		{"w_100,dpr_2/" + testURL, "w_100,dpr_2.0/" + testURL, true, "dpr format"},
+		{"w_100/" + testURL, "w_100,dpr_2/" + testURL, false, "dpr"},
+		{"w_0.5/" + testURL, "w_0.50/" + testURL, true, "relative format"},
+		{"w_0.5/" + testURL, "w_0.6/" + testURL, false, "relative"},
+		{"w_100/" + testURL, "w_100,f_png/" + testURL, false, "format"},
+		{"w_100/" + testURL, "w_100/" + testSecureURL, false, "source"},
+	}
+	for _, test := range cases {
+		if strings.Contains(test.a, "f_avif") && !bimg.IsTypeSupportedSave(bimg.AVIF) {
+			// libvips built without libheif
+			continue
+		}
+		jobA, jobB := NewJob(), NewJob()
+		assert.Nil(t, jobA.Parse(test.a, true))
+		assert.Nil(t, jobB.Parse(test.b, true))
+		assert.Equal(t, test.same, jobA.Target.Hash == jobB.Target.Hash, test.description)
+	}
+}
+
+func TestParseVary(t *testing.T) {
+	job := NewJob()
+	job.AcceptWebp = true
+	assert.Nil(t, job.Parse("w_100,f_auto/f_auto/"+testURL, true))
+	assert.Equal(t, bimg.WEBP, job.Target.Format)
+	assert.Equal(t, []string{"Accept"}, job.Vary)
+
+	job = NewJob()
+	assert.Nil(t, job.Parse("w_100,f_webp/"+testURL, true))
+	assert.Nil(t, job.Vary)
 }
 
 func TestParseFail(t *testing.T) {
@@ -264,21 +606,357 @@ var cropCases = []struct {
 	{"limit", 500, 1000, 100, 50, 100, 200, "limit ver-ver"},
 	{"limit", 1000, 500, 50, 100, 200, 100, "limit hor-ver"},
 	{"limit", 500, 1000, 50, 100, 50, 100, "limit ver-hor"},
+	{"crop", 1000, 500, 100, 50, 100, 50, "crop region"},
+	{"crop", 1000, 500, 2000, 0, 1000, 500, "crop bigger"},
+	{"pad", 1000, 500, 100, 100, 100, 100, "pad hor-square"},
+	{"pad", 1000, 500, 0, 100, 200, 100, "pad without w"},
+	{"lpad", 50, 100, 500, 500, 500, 500, "lpad bigger"},
+	{"fill", 1000, 500, 100, 100, 100, 100, "fill hor-square"},
+	{"fill", 500, 1000, 200, 100, 200, 100, "fill ver-hor"},
+	{"fill", 50, 100, 500, 500, 500, 500, "fill bigger"},
+	{"fill", 1000, 500, 0, 100, 200, 100, "fill without w"},
+	{"thumb", 1000, 500, 100, 100, 100, 100, "thumb hor-square"},
+	{"thumb", 50, 100, 500, 500, 50, 50, "thumb bigger"},
+	{"thumb", 1000, 500, 2000, 100, 1000, 50, "thumb wider than source"},
+	{"fill", 1000, 500, 1, 0, 1, 1, "fill tiny width"},
+	{"pad", 1000, 500, 1, 0, 1, 1, "pad tiny width"},
 }
 
 func TestCrop(t *testing.T) {
 	for _, test := range cropCases {
-		job := NewJob()
-		job.Source.Width = test.sourceWidth
-		job.Source.Height = test.sourceHeight
-		job.Source.AspectRatio = float32(test.sourceWidth) / float32(test.sourceHeight)
-		job.Target.Width = test.targetWidth
-		job.Target.Height = test.targetHeight
-		job.Filters["crop"] = test.crop
-
-		err := job.Crop()
+		step := newStep()
+		step.Source.Width = test.sourceWidth
+		step.Source.Height = test.sourceHeight
+		step.Source.AspectRatio = float32(test.sourceWidth) / float32(test.sourceHeight)
+		step.Target.Width = test.targetWidth
+		step.Target.Height = test.targetHeight
+		step.Filters["crop"] = test.crop
+
+		err := step.Crop()
+		assert.Nil(t, err)
+		assert.Equal(t, test.expectedHeight, step.Target.Height, test.message)
+		assert.Equal(t, test.expectedWidth, step.Target.Width, test.message)
+	}
+}
+
+var fillCases = []struct {
+	crop         string
+	gravity      string
+	sourceWidth  int
+	sourceHeight int
+	targetWidth  int
+	targetHeight int
+	area         Area
+	message      string
+}{
+	{"fill", "", 1000, 500, 100, 100, Area{Left: 250, Top: 0, Width: 500, Height: 500}, "fill center"},
+	{"fill", "west", 1000, 500, 100, 100, Area{Left: 0, Top: 0, Width: 500, Height: 500}, "fill west"},
+	{"fill", "east", 1000, 500, 100, 100, Area{Left: 500, Top: 0, Width: 500, Height: 500}, "fill east"},
+	{"fill", "north", 500, 1000, 200, 100, Area{Left: 0, Top: 0, Width: 500, Height: 250}, "fill north"},
+	{"fill", "south_east", 500, 1000, 200, 100, Area{Left: 0, Top: 750, Width: 500, Height: 250}, "fill south east"},
+	{"thumb", "north_west", 50, 100, 500, 500, Area{Left: 0, Top: 0, Width: 50, Height: 50}, "thumb bigger"},
+	{"fill", "face", 1000, 500, 100, 100, Area{Left: 250, Top: 0, Width: 500, Height: 500}, "fill face without faces"},
+	{"fill", "", 1000, 500, 1, 0, Area{Left: 250, Top: 0, Width: 500, Height: 500}, "fill tiny width"},
+	{"fill", "", 1000, 500, 1, 1000, Area{Left: 499, Top: 0, Width: 1, Height: 500}, "fill extreme ratio"},
+}
+
+func TestCropFill(t *testing.T) {
+	for _, test := range fillCases {
+		step := newStep()
+		step.Source.Width = test.sourceWidth
+		step.Source.Height = test.sourceHeight
+		step.Source.AspectRatio = float32(test.sourceWidth) / float32(test.sourceHeight)
+		step.Target.Width = test.targetWidth
+		step.Target.Height = test.targetHeight
+		step.Filters["crop"] = test.crop
+		step.Filters["gravity"] = test.gravity
+
+		err := step.Crop()
+		assert.Nil(t, err)
+		assert.Equal(t, &test.area, step.Target.Extract, test.message)
+	}
+}
+
+var regionCases = []struct {
+	x            string
+	y            string
+	gravity      string
+	targetWidth  int
+	targetHeight int
+	area         Area
+	message      string
+}{
+	{"10", "20", "", 100, 50, Area{Left: 10, Top: 20, Width: 100, Height: 50}, "crop with coordinates"},
+	{"950", "480", "", 100, 50, Area{Left: 900, Top: 450, Width: 100, Height: 50}, "crop outside image"},
+	{"", "", "", 100, 50, Area{Left: 450, Top: 225, Width: 100, Height: 50}, "crop centered"},
+	{"", "", "south_east", 100, 50, Area{Left: 900, Top: 450, Width: 100, Height: 50}, "crop with gravity"},
+	{"10", "", "south", 100, 50, Area{Left: 10, Top: 450, Width: 100, Height: 50}, "crop with x and gravity"},
+}
+
+func TestCropRegion(t *testing.T) {
+	for _, test := range regionCases {
+		step := newStep()
+		step.Source.Width = 1000
+		step.Source.Height = 500
+		step.Source.AspectRatio = 2
+		step.Target.Width = test.targetWidth
+		step.Target.Height = test.targetHeight
+		step.Filters["crop"] = "crop"
+		step.Filters["gravity"] = test.gravity
+		if test.x != "" {
+			step.Filters["x"] = test.x
+		}
+		if test.y != "" {
+			step.Filters["y"] = test.y
+		}
+
+		err := step.Crop()
+		assert.Nil(t, err)
+		assert.Equal(t, &test.area, step.Target.Extract, test.message)
+	}
+}
+
+var padCases = []struct {
+	crop         string
+	gravity      string
+	sourceWidth  int
+	sourceHeight int
+	targetWidth  int
+	targetHeight int
+	area         Area
+	message      string
+}{
+	{"pad", "", 1000, 500, 100, 100, Area{Left: 0, Top: 25, Width: 100, Height: 50}, "pad hor-square"},
+	{"pad", "north", 1000, 500, 100, 100, Area{Left: 0, Top: 0, Width: 100, Height: 50}, "pad north"},
+	{"pad", "", 500, 1000, 100, 100, Area{Left: 25, Top: 0, Width: 50, Height: 100}, "pad ver-square"},
+	{"pad", "", 50, 100, 500, 500, Area{Left: 125, Top: 0, Width: 250, Height: 500}, "pad bigger"},
+	{"lpad", "", 50, 100, 500, 500, Area{Left: 225, Top: 200, Width: 50, Height: 100}, "lpad bigger"},
+	{"lpad", "south_west", 50, 100, 500, 500, Area{Left: 0, Top: 400, Width: 50, Height: 100}, "lpad bigger south west"},
+	{"pad", "", 1000, 500, 1, 0, Area{Left: 0, Top: 0, Width: 1, Height: 1}, "pad tiny width"},
+}
+
+func TestCropPad(t *testing.T) {
+	for _, test := range padCases {
+		step := newStep()
+		step.Source.Width = test.sourceWidth
+		step.Source.Height = test.sourceHeight
+		step.Source.AspectRatio = float32(test.sourceWidth) / float32(test.sourceHeight)
+		step.Target.Width = test.targetWidth
+		step.Target.Height = test.targetHeight
+		step.Filters["crop"] = test.crop
+		step.Filters["gravity"] = test.gravity
+
+		err := step.Crop()
 		assert.Nil(t, err)
-		assert.Equal(t, test.expectedHeight, job.Target.Height, test.message)
-		assert.Equal(t, test.expectedWidth, job.Target.Width, test.message)
+		assert.Equal(t, &test.area, step.Target.Embed, test.message)
+		assert.Equal(t, white, step.Target.Background, test.message)
+	}
+}
+
+func TestJobProcess(t *testing.T) {
+	job := NewJob()
+	err := job.parseFilters("w_500,h_500,c_fill/w_100,c_limit,f_png")
+	assert.Nil(t, err)
+	r, _ := os.Open("testdata/fiveyears.jpg")
+	job.Source.Load(r)
+
+	err = job.Process(nil)
+	assert.Nil(t, err)
+	size, _ := bimg.NewImage(job.Target.RawContent).Size()
+	assert.Equal(t, 100, size.Width)
+	assert.Equal(t, 100, size.Height)
+	assert.Equal(t, "png", bimg.DetermineImageTypeName(job.Target.RawContent))
+}
+
+func TestParseAnimation(t *testing.T) {
+	job := NewJob()
+	assert.Nil(t, job.Parse("w_100,fl_animated,pg_2/"+testURL, true))
+	assert.True(t, job.Animated)
+	assert.Equal(t, 2, job.Page)
+}
+
+func TestJobProcessAnimated(t *testing.T) {
+	job := NewJob()
+	err := job.parseFilters("w_60,c_fill,fl_animated,f_gif")
+	assert.Nil(t, err)
+	r, _ := os.Open("testdata/animated.gif")
+	job.Source.Load(r)
+
+	err = job.Process(nil)
+	assert.Nil(t, err)
+	g, err := gif.DecodeAll(bytes.NewReader(job.Target.RawContent))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(g.Image))
+	assert.Equal(t, 60, g.Config.Width)
+}
+
+func TestJobProcessPage(t *testing.T) {
+	job := NewJob()
+	err := job.parseFilters("w_60,pg_3,f_png")
+	assert.Nil(t, err)
+	r, _ := os.Open("testdata/animated.gif")
+	job.Source.Load(r)
+
+	err = job.Process(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "png", bimg.DetermineImageTypeName(job.Target.RawContent))
+
+	job = NewJob()
+	job.parseFilters("pg_9")
+	r, _ = os.Open("testdata/animated.gif")
+	job.Source.Load(r)
+	assert.Equal(t, errors.New("page 9 not found"), job.Process(nil))
+}
+
+func TestJobProcessDocument(t *testing.T) {
+	job := NewJob()
+	err := job.parseFilters("w_50,pg_2,dn_144,f_png")
+	assert.Nil(t, err)
+	r, _ := os.Open("testdata/flyer.pdf")
+	job.Source.Load(r)
+
+	err = job.Process(nil)
+	assert.Nil(t, err)
+	size, _ := bimg.NewImage(job.Target.RawContent).Size()
+	assert.Equal(t, 50, size.Width)
+	assert.Equal(t, 100, size.Height, "second page is vertical")
+	assert.Equal(t, "png", bimg.DetermineImageTypeName(job.Target.RawContent))
+}
+
+func TestParseDPRAuto(t *testing.T) {
+	job := NewJob()
+	job.ClientDPR = 2.63
+	assert.Nil(t, job.Parse("w_100,dpr_auto/"+testURL, true))
+	assert.Equal(t, 2.6, job.DPR)
+	assert.Equal(t, []string{"DPR", "Sec-CH-DPR", "Save-Data"}, job.Vary)
+
+	job = NewJob()
+	assert.Nil(t, job.Parse("w_100,dpr_auto/"+testURL, true))
+	assert.Equal(t, 1.0, job.DPR, "without client hint")
+}
+
+func TestParseAutoWidth(t *testing.T) {
+	cases := []struct {
+		filters     string
+		width       int
+		viewport    int
+		dpr         float64
+		saveData    bool
+		expected    int
+		auto        bool
+		description string
+	}{
+		{"w_auto", 700, 0, 0, false, 768, true, "width hint rounded up"},
+		{"w_auto", 0, 400, 2, false, 960, true, "viewport width times dpr"},
+		{"w_auto", 5000, 0, 0, false, 2560, true, "biggest breakpoint"},
+		{"w_auto:300", 0, 0, 0, false, 300, false, "fallback without hints"},
+		{"w_auto", 0, 400, 2, true, 480, true, "save data ignores dpr"},
 	}
+	for _, test := range cases {
+		job := NewJob()
+		job.ClientWidth = test.width
+		job.ClientViewportWidth = test.viewport
+		job.ClientDPR = test.dpr
+		job.SaveData = test.saveData
+		assert.Nil(t, job.Parse(test.filters+"/"+testURL, true), test.description)
+		assert.Equal(t, test.expected, job.Steps[0].Target.Width, test.description)
+		assert.Equal(t, test.auto, job.Steps[0].AutoWidth, test.description)
+	}
+}
+
+func TestParseAutoWidthVaryDPR(t *testing.T) {
+	vary := []string{
+		"Sec-CH-Width", "Width", "Sec-CH-Viewport-Width", "Viewport-Width",
+		"DPR", "Sec-CH-DPR", "Save-Data",
+	}
+	widths := []int{}
+	for _, dpr := range []float64{1, 3} {
+		job := NewJob()
+		job.ClientViewportWidth = 400
+		job.ClientDPR = dpr
+		assert.Nil(t, job.Parse("w_auto/"+testURL, true))
+		assert.Equal(t, vary, job.Vary, "viewport width depends on dpr")
+		widths = append(widths, job.Steps[0].Target.Width)
+	}
+	assert.Equal(t, []int{480, 1280}, widths)
+}
+
+func TestParseAutoWidthFail(t *testing.T) {
+	job := NewJob()
+	assert.Equal(t, fmt.Errorf("targetWidth autofit not allowed"), job.Parse("w_autofit/"+testURL, true))
+	job = NewJob()
+	assert.NotNil(t, job.Parse("w_auto:big/"+testURL, true))
+}
+
+func TestParseAutoWidthVary(t *testing.T) {
+	job := NewJob()
+	job.SaveData = true
+	job.Breakpoints = []int{100, 200}
+	job.ClientWidth = 150
+	assert.Nil(t, job.Parse("w_auto,dpr_auto/"+testURL, true))
+	assert.Equal(t, 200, job.Steps[0].Target.Width)
+	assert.Equal(t, saveDataQuality, job.Target.Quality)
+	assert.Equal(t, 1.0, job.DPR)
+	vary := []string{
+		"Sec-CH-Width", "Width", "Sec-CH-Viewport-Width", "Viewport-Width",
+		"DPR", "Sec-CH-DPR", "Save-Data",
+	}
+	assert.Equal(t, vary, job.Vary)
+
+	job = NewJob()
+	job.ClientWidth = 150
+	assert.Nil(t, job.Parse("w_auto,dpr_auto/"+testURL, true))
+	assert.Equal(t, vary, job.Vary, "same vary without save data")
+
+	job = NewJob()
+	job.SaveData = true
+	assert.Nil(t, job.Parse("w_100/"+testURL, true))
+	assert.Equal(t, 0, job.Target.Quality, "save data only with hints")
+	assert.Empty(t, job.Vary)
+}
+
+func TestBreakpoint(t *testing.T) {
+	assert.Equal(t, 320, breakpoint(1, nil))
+	assert.Equal(t, 640, breakpoint(640, nil))
+	assert.Equal(t, 500, breakpoint(450, []int{250, 500}))
+	assert.Equal(t, 500, breakpoint(900, []int{250, 500}))
+}
+
+func TestStepResolveAutoWidth(t *testing.T) {
+	step := newStep()
+	step.AutoWidth = true
+	step.Target.Width = 640
+	step.Target.Height = 100
+	step.resolve(2)
+	assert.Equal(t, 640, step.Target.Width, "hinted width is physical")
+	assert.Equal(t, 200, step.Target.Height)
+}
+
+func TestClampDPR(t *testing.T) {
+	assert.Equal(t, 1.0, clampDPR(0))
+	assert.Equal(t, 1.0, clampDPR(math.NaN()))
+	assert.Equal(t, 1.0, clampDPR(math.Inf(1)))
+	assert.Equal(t, 1.5, clampDPR(1.5))
+	assert.Equal(t, 4.0, clampDPR(10))
+}
+
+func TestStepResolve(t *testing.T) {
+	step := newStep()
+	step.Source = Image{Width: 1000, Height: 500}
+	step.RelativeWidth = 0.25
+	step.Target.Height = 100
+	step.Target.Radius = 10
+	step.Filters["x"] = "15"
+	step.resolve(2)
+	assert.Equal(t, 500, step.Target.Width, "relative to source and multiplied by dpr")
+	assert.Equal(t, 200, step.Target.Height)
+	assert.Equal(t, 20, step.Target.Radius)
+	assert.Equal(t, "30", step.Filters["x"])
+
+	step = newStep()
+	step.Source = Image{Width: 1000, Height: 500}
+	step.Target.Radius = roundMax
+	step.resolve(2)
+	assert.Equal(t, 0, step.Target.Width, "missing dimensions are kept")
+	assert.Equal(t, roundMax, step.Target.Radius)
 }
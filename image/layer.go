@@ -0,0 +1,148 @@
+package image
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/h2non/bimg"
+	"github.com/trilopin/godinary/storage"
+)
+
+// layer loads the overlay of a layer step. Text layers are rendered,
+// otherwise it comes from upload storage and ids are uploaded file names.
+func (job *Job) layer(step *Step, sd storage.Driver) ([]byte, error) {
+	id := step.Filters["layer"]
+	if strings.HasPrefix(id, "text:") {
+		text, err := parseText(strings.TrimPrefix(id, "text:"))
+		if err != nil {
+			return nil, err
+		}
+		c, err := step.color()
+		if err != nil {
+			return nil, err
+		}
+		return text.render(c)
+	}
+	if sd == nil {
+		return nil, fmt.Errorf("layer \"%s\" needs storage", id)
+	}
+	reader, err := sd.NewReader(job.Hasher.Hash(id), "upload/")
+	if err != nil {
+		return nil, fmt.Errorf("layer \"%s\" not found", id)
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// overlay composes the layer over every frame. Dimensions, crop, angle and
+// effect of the step apply to the layer, which is placed following gravity
+// and offsets.
+func (step *Step) overlay(frames [][]byte, layer []byte, dpr float64) error {
+	step.Source = Image{Content: bimg.NewImage(frames[0])}
+	if err := step.Source.ExtractInfo(); err != nil {
+		return err
+	}
+	// relative dimensions of layers refer to the image below
+	step.resolve(dpr)
+
+	buf, err := step.layer(layer)
+	if err != nil {
+		return err
+	}
+	size, err := bimg.NewImage(buf).Size()
+	if err != nil {
+		return fmt.Errorf("can't extract layer dimensions: %v", err)
+	}
+	area := step.place(size.Width, size.Height)
+	x, _ := strconv.Atoi(step.Filters["x"])
+	y, _ := strconv.Atoi(step.Filters["y"])
+	area.offset(step.Filters["gravity"], x, y)
+
+	opacity := 1.0
+	if o, err := strconv.Atoi(step.Filters["opacity"]); err == nil {
+		opacity = float64(o) / 100
+	}
+	format := step.Target.Format
+	if step.Target.lossless {
+		format = bimg.PNG
+	}
+	options := bimg.Options{
+		Type:    format,
+		Quality: step.Target.Quality,
+		WatermarkImage: bimg.WatermarkImage{
+			Left:    area.Left,
+			Top:     area.Top,
+			Buf:     buf,
+			Opacity: float32(opacity),
+		},
+	}
+	for n := range frames {
+		if frames[n], err = bimg.NewImage(frames[n]).Process(options); err != nil {
+			return fmt.Errorf("can't compose layer: %v", err)
+		}
+	}
+	step.Target.Width, step.Target.Height = step.Source.Width, step.Source.Height
+	step.Target.RawContent = frames[0]
+	return nil
+}
+
+// layer transforms the overlay with step filters, except the ones used to
+// place it, and returns it as png
+func (step *Step) layer(buf []byte) ([]byte, error) {
+	layer := &Step{Target: step.Target, Filters: make(map[string]string)}
+	for name, value := range step.Filters {
+		switch name {
+		case "gravity", "x", "y", "layer", "opacity", "color":
+		default:
+			layer.Filters[name] = value
+		}
+	}
+	layer.Target.Format = bimg.PNG
+	layer.Target.Quality = 0
+	layer.Target.lossless = false
+	// a single dimension keeps the aspect ratio of the layer
+	if layer.Filters["crop"] == "scale" && (layer.Target.Width == 0 || layer.Target.Height == 0) {
+		layer.Filters["crop"] = "fit"
+	}
+
+	frames := [][]byte{buf}
+	if err := layer.rotate(frames); err != nil {
+		return nil, err
+	}
+	layer.Source = Image{Content: bimg.NewImage(frames[0])}
+	if err := layer.Source.ExtractInfo(); err != nil {
+		return nil, err
+	}
+	if err := layer.Crop(); err != nil {
+		return nil, err
+	}
+	if err := layer.Target.Process(layer.Source, nil); err != nil {
+		return nil, err
+	}
+	return layer.Target.RawContent, nil
+}
+
+// offset moves an area placed with gravity, offsets go away from the
+// edges given by gravity
+func (area *Area) offset(gravity string, x, y int) {
+	if strings.HasSuffix(gravity, "east") {
+		area.Left -= x
+	} else {
+		area.Left += x
+	}
+	if strings.HasPrefix(gravity, "south") {
+		area.Top -= y
+	} else {
+		area.Top += y
+	}
+}
+
+// color resolves the color filter of text layers, black by default
+func (step *Step) color() (bimg.Color, error) {
+	if step.Filters["color"] == "" {
+		return namedColors["black"], nil
+	}
+	return parseColor(step.Filters["color"])
+}
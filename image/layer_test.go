@@ -0,0 +1,78 @@
+package image
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/h2non/bimg"
+	"github.com/stretchr/testify/assert"
+	"github.com/trilopin/godinary/storage"
+)
+
+func TestAreaOffset(t *testing.T) {
+	cases := []struct {
+		gravity     string
+		expected    Area
+		description string
+	}{
+		{"north_west", Area{Left: 60, Top: 70, Width: 10, Height: 10}, "away from top left"},
+		{"south_east", Area{Left: 40, Top: 30, Width: 10, Height: 10}, "away from bottom right"},
+		{"center", Area{Left: 60, Top: 70, Width: 10, Height: 10}, "center"},
+	}
+	for _, test := range cases {
+		area := &Area{Left: 50, Top: 50, Width: 10, Height: 10}
+		area.offset(test.gravity, 10, 20)
+		assert.Equal(t, test.expected, *area, test.description)
+	}
+}
+
+func TestJobProcessLayer(t *testing.T) {
+	base, _ := ioutil.TempDir("", "godinary")
+	defer os.RemoveAll(base)
+	sd := storage.NewFileDriver(base + "/")
+
+	red := image.NewUniform(color.RGBA{R: 255, A: 255})
+	logo := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			logo.Set(x, y, red)
+		}
+	}
+	buf, _ := encodePNG(logo)
+	job := NewJob()
+	sd.Write(buf, job.Hasher.Hash("logo.png"), "upload/")
+
+	err := job.parseFilters("w_300,c_fit/l_logo.png,w_20,g_south_east,x_5,y_5,f_png")
+	assert.Nil(t, err)
+	r, _ := os.Open("testdata/fiveyears.jpg")
+	job.Source.Load(r)
+
+	err = job.Process(sd)
+	assert.Nil(t, err)
+	size, _ := bimg.NewImage(job.Target.RawContent).Size()
+	assert.Equal(t, 300, size.Width, "base dimensions are kept")
+	assert.Equal(t, 300, job.Target.Width)
+
+	out, err := decodeRGBA(job.Target.RawContent)
+	assert.Nil(t, err)
+	// layer is 20x10 placed 5 pixels away from bottom right corner
+	c := out.RGBAAt(size.Width-15, size.Height-10)
+	assert.True(t, c.R > 200 && c.G < 50 && c.B < 50, "layer is composed")
+}
+
+func TestJobProcessLayerNotFound(t *testing.T) {
+	base, _ := ioutil.TempDir("", "godinary")
+	defer os.RemoveAll(base)
+
+	job := NewJob()
+	job.parseFilters("w_300/l_fake.png")
+	r, _ := os.Open("testdata/fiveyears.jpg")
+	job.Source.Load(r)
+
+	err := job.Process(storage.NewFileDriver(base + "/"))
+	assert.Equal(t, errors.New("layer \"fake.png\" not found"), err)
+}
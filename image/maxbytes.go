@@ -0,0 +1,96 @@
+package image
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/h2non/bimg"
+)
+
+// minMaxBytes is the smallest size budget allowed by fl_maxbytes
+const minMaxBytes = 1024
+
+// minBudgetQuality is the lowest quality used to fit a size budget before
+// reducing dimensions
+const minBudgetQuality = 20
+
+// lossyFormats accept a quality lowering their size
+var lossyFormats = map[bimg.ImageType]bool{
+	bimg.JPEG: true,
+	bimg.WEBP: true,
+	bimg.AVIF: true,
+	bimg.HEIF: true,
+}
+
+// encoder encodes lossless frames with the given quality
+type encoder func(frames [][]byte, quality int) ([]byte, error)
+
+// fit encodes lossless frames within MaxBytes, lowering quality from the
+// given one first and then dimensions of every frame
+func (img *Image) fit(frames [][]byte, quality int, encode encoder) ([]byte, error) {
+	size, err := bimg.NewImage(frames[0]).Size()
+	if err != nil {
+		return nil, err
+	}
+	width, height := size.Width, size.Height
+	for {
+		encoded, err := img.budgetQuality(frames, quality, encode)
+		if err != nil {
+			return nil, err
+		}
+		if len(encoded) <= img.MaxBytes {
+			return encoded, nil
+		}
+
+		// bytes grow with area, dimensions shrink by the square root of
+		// the excess and at least a tenth
+		scale := math.Min(math.Sqrt(float64(img.MaxBytes)/float64(len(encoded))), 0.9)
+		width, height = int(float64(width)*scale), int(float64(height)*scale)
+		if width == 0 || height == 0 {
+			return nil, fmt.Errorf("can't fit image in %d bytes", img.MaxBytes)
+		}
+		options := bimg.Options{Width: width, Height: height, Force: true, Type: bimg.PNG}
+		resized := make([][]byte, len(frames))
+		for n, frame := range frames {
+			if resized[n], err = bimg.NewImage(frame).Process(options); err != nil {
+				return nil, err
+			}
+		}
+		frames = resized
+	}
+}
+
+// budgetQuality encodes frames with the highest quality up to the given
+// one within MaxBytes, the lowest one when none fits. Lossless formats are
+// encoded once.
+func (img *Image) budgetQuality(frames [][]byte, high int, encode encoder) ([]byte, error) {
+	if !lossyFormats[img.Format] {
+		return encode(frames, high)
+	}
+	if high == 0 {
+		high = defaultQuality
+	}
+	low := minBudgetQuality
+	if low > high {
+		low = high
+	}
+	smallest, err := encode(frames, low)
+	if err != nil || len(smallest) > img.MaxBytes {
+		return smallest, err
+	}
+	best := smallest
+	for low < high {
+		quality := (low + high + 1) / 2
+		encoded, err := encode(frames, quality)
+		if err != nil {
+			return nil, err
+		}
+		if len(encoded) <= img.MaxBytes {
+			best = encoded
+			low = quality
+		} else {
+			high = quality - 1
+		}
+	}
+	return best, nil
+}
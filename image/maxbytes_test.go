@@ -0,0 +1,61 @@
+package image
+
+import (
+	"bytes"
+	"image/gif"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/h2non/bimg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMaxBytes(t *testing.T) {
+	job := NewJob()
+	assert.Nil(t, job.Parse("w_100,fl_maxbytes:50000/"+testURL, true))
+	assert.Equal(t, 50000, job.Target.MaxBytes)
+	assert.Contains(t, job.canonical(), "/fl_maxbytes:50000/")
+}
+
+func TestJobProcessMaxBytes(t *testing.T) {
+	cases := []struct {
+		filters  string
+		maxBytes int
+		resized  bool
+		message  string
+	}{
+		{"w_300,h_200,c_fill,q_95,f_jpg", 12000, false, "lower quality"},
+		{"w_300,h_200,c_fill,q_95,f_jpg", 2000, true, "lower dimensions"},
+		{"w_300,h_200,c_fill,f_png", 20000, true, "lossless format"},
+		{"w_300,h_200,c_fill/l_text:go_20:Hello,g_south,q_95,f_jpg", 12000, false, "trailing layer"},
+	}
+	for _, test := range cases {
+		job := NewJob()
+		err := job.parseFilters(test.filters + ",fl_maxbytes:" + strconv.Itoa(test.maxBytes))
+		assert.Nil(t, err, test.message)
+		r, _ := os.Open("testdata/fiveyears.jpg")
+		job.Source.Load(r)
+
+		err = job.Process(nil)
+		assert.Nil(t, err, test.message)
+		assert.True(t, len(job.Target.RawContent) <= test.maxBytes, test.message)
+		size, _ := bimg.NewImage(job.Target.RawContent).Size()
+		assert.Equal(t, test.resized, size.Width < 300, test.message)
+	}
+}
+
+func TestJobProcessMaxBytesAnimated(t *testing.T) {
+	job := NewJob()
+	err := job.parseFilters("w_60,c_fill,fl_animated,f_gif,fl_maxbytes:1024")
+	assert.Nil(t, err)
+	r, _ := os.Open("testdata/animated.gif")
+	job.Source.Load(r)
+
+	err = job.Process(nil)
+	assert.Nil(t, err)
+	assert.True(t, len(job.Target.RawContent) <= 1024)
+	g, err := gif.DecodeAll(bytes.NewReader(job.Target.RawContent))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(g.Image), "every frame is kept")
+}
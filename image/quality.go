@@ -0,0 +1,145 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+// qualityLevels are the minimum similarity to the lossless image accepted
+// by q_auto, good is used when no level is given
+var qualityLevels = map[string]float64{
+	"best": 0.99,
+	"good": 0.98,
+	"eco":  0.96,
+}
+
+// autoQualityFormats are lossy formats q_auto searches quality for, other
+// formats use encoder defaults
+var autoQualityFormats = map[bimg.ImageType]bool{
+	bimg.JPEG: true,
+	bimg.WEBP: true,
+}
+
+// quality range searched by q_auto
+const (
+	minAutoQuality = 30
+	maxAutoQuality = 95
+)
+
+// ssimWindow is the side of the squares compared by ssim
+const ssimWindow = 8
+
+// parseAutoQuality accepts auto and auto:level
+func parseAutoQuality(s string) (string, error) {
+	if s == "auto" {
+		return "good", nil
+	}
+	level := strings.TrimPrefix(s, "auto:")
+	if _, ok := qualityLevels[level]; !ok || level == s {
+		return "", fmt.Errorf("quality %s not allowed", s)
+	}
+	return level, nil
+}
+
+// autoQuality returns the lowest quality whose encoding of a lossless
+// buffer reaches the similarity threshold of the level
+func (img *Image) autoQuality(buf []byte) (int, error) {
+	reference, err := decodeRGBA(buf)
+	if err != nil {
+		return 0, err
+	}
+	threshold := qualityLevels[img.AutoQuality]
+
+	best := maxAutoQuality
+	low, high := minAutoQuality, maxAutoQuality
+	for low <= high {
+		quality := (low + high) / 2
+		encoded, err := bimg.NewImage(buf).Process(bimg.Options{Type: img.Format, Quality: quality})
+		if err != nil {
+			return 0, err
+		}
+		decoded, err := bimg.NewImage(encoded).Process(bimg.Options{Type: bimg.PNG})
+		if err != nil {
+			return 0, err
+		}
+		candidate, err := decodeRGBA(decoded)
+		if err != nil {
+			return 0, err
+		}
+		if ssim(reference, candidate) >= threshold {
+			best = quality
+			high = quality - 1
+		} else {
+			low = quality + 1
+		}
+	}
+	return best, nil
+}
+
+// luma returns the luminance plane of an image, colors are alpha
+// premultiplied so transparency is compared as black
+func luma(img *image.RGBA) []float64 {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	plane := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*img.Stride + x*4
+			plane[y*width+x] = 0.299*float64(img.Pix[i]) + 0.587*float64(img.Pix[i+1]) + 0.114*float64(img.Pix[i+2])
+		}
+	}
+	return plane
+}
+
+// ssim is the mean structural similarity of the luminance of two images
+// of the same size over square windows, 1 means identical
+func ssim(a, b *image.RGBA) float64 {
+	width, height := a.Rect.Dx(), a.Rect.Dy()
+	if width != b.Rect.Dx() || height != b.Rect.Dy() {
+		return 0
+	}
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	la, lb := luma(a), luma(b)
+
+	var total float64
+	var windows int
+	for top := 0; top < height; top += ssimWindow {
+		for left := 0; left < width; left += ssimWindow {
+			bottom, right := top+ssimWindow, left+ssimWindow
+			if bottom > height {
+				bottom = height
+			}
+			if right > width {
+				right = width
+			}
+			n := float64((bottom - top) * (right - left))
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for y := top; y < bottom; y++ {
+				for x := left; x < right; x++ {
+					va, vb := la[y*width+x], lb[y*width+x]
+					sumA += va
+					sumB += vb
+					sumAA += va * va
+					sumBB += vb * vb
+					sumAB += va * vb
+				}
+			}
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			cov := sumAB/n - meanA*meanB
+			total += ((2*meanA*meanB + c1) * (2*cov + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+	if windows == 0 {
+		return 1
+	}
+	return total / float64(windows)
+}
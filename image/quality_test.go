@@ -0,0 +1,94 @@
+package image
+
+import (
+	"errors"
+	"image"
+	"os"
+	"testing"
+
+	"github.com/h2non/bimg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAutoQuality(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected string
+		err      error
+	}{
+		{"auto", "good", nil},
+		{"auto:best", "best", nil},
+		{"auto:eco", "eco", nil},
+		{"auto:", "", errors.New("quality auto: not allowed")},
+		{"autoeco", "", errors.New("quality autoeco not allowed")},
+	}
+	for _, test := range cases {
+		level, err := parseAutoQuality(test.value)
+		assert.Equal(t, test.expected, level, test.value)
+		assert.Equal(t, test.err, err, test.value)
+	}
+}
+
+func TestSSIM(t *testing.T) {
+	a := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for i := range a.Pix {
+		a.Pix[i] = uint8(i * 7)
+	}
+	b := image.NewRGBA(a.Rect)
+	copy(b.Pix, a.Pix)
+	assert.InDelta(t, 1.0, ssim(a, b), 1e-9, "identical images")
+
+	for i := 0; i < len(b.Pix); i += 8 {
+		b.Pix[i] = 255 - b.Pix[i]
+	}
+	assert.True(t, ssim(a, b) < 0.9, "noisy image")
+	assert.Equal(t, 0.0, ssim(a, image.NewRGBA(image.Rect(0, 0, 10, 10))), "different sizes")
+}
+
+func TestJobAutoQuality(t *testing.T) {
+	job := NewJob()
+	assert.Nil(t, job.Parse("w_100,q_auto:eco/"+testURL, true))
+	assert.Equal(t, "eco", job.Target.AutoQuality)
+	assert.Contains(t, job.canonical(), "/q_auto:eco/")
+
+	job = NewJob()
+	job.SaveData = true
+	job.ClientWidth = 300
+	assert.Nil(t, job.Parse("w_auto,q_auto:best/"+testURL, true))
+	assert.Equal(t, "eco", job.Target.AutoQuality, "save data lowers level")
+	assert.Equal(t, 0, job.Target.Quality)
+}
+
+func TestJobProcessAutoQuality(t *testing.T) {
+	sizes := map[string]int{}
+	for level := range qualityLevels {
+		job := NewJob()
+		err := job.parseFilters("w_300,h_200,c_fill,f_jpg,q_auto:" + level)
+		assert.Nil(t, err)
+		r, _ := os.Open("testdata/fiveyears.jpg")
+		job.Source.Load(r)
+
+		err = job.Process(nil)
+		assert.Nil(t, err, level)
+		assert.Equal(t, "jpeg", bimg.DetermineImageTypeName(job.Target.RawContent), level)
+		sizes[level] = len(job.Target.RawContent)
+	}
+	assert.True(t, sizes["eco"] <= sizes["good"], "eco is lighter than good")
+	assert.True(t, sizes["good"] <= sizes["best"], "good is lighter than best")
+}
+
+func TestJobProcessAutoQualityLayer(t *testing.T) {
+	sizes := map[string]int{}
+	for _, quality := range []string{"q_95", "q_auto:eco"} {
+		job := NewJob()
+		err := job.parseFilters("w_300,h_200,c_fill/l_text:go_20:Hello,g_south,f_jpg," + quality)
+		assert.Nil(t, err)
+		r, _ := os.Open("testdata/fiveyears.jpg")
+		job.Source.Load(r)
+
+		err = job.Process(nil)
+		assert.Nil(t, err, quality)
+		sizes[quality] = len(job.Target.RawContent)
+	}
+	assert.True(t, sizes["q_auto:eco"] < sizes["q_95"], "quality searched after the layer")
+}
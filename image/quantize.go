@@ -0,0 +1,119 @@
+package image
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// maxPaletteColors is the size of gif palettes
+const maxPaletteColors = 256
+
+// bucket gathers opaque colors with the same 5 most significant bits per
+// channel, sums keep their average
+type bucket struct {
+	rgb   [3]int
+	count int
+	sum   [3]int
+}
+
+// quantize builds a palette for an image by median cut over its colors.
+// Mostly transparent pixels share a transparent color.
+func quantize(img *image.RGBA) color.Palette {
+	buckets := make([]bucket, 1<<15)
+	transparent := false
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			i := y*img.Stride + x*4
+			a := int(img.Pix[i+3])
+			if a < 128 {
+				transparent = true
+				continue
+			}
+			// colors are alpha premultiplied
+			r, g, b := int(img.Pix[i])*255/a, int(img.Pix[i+1])*255/a, int(img.Pix[i+2])*255/a
+			bk := &buckets[r>>3<<10|g>>3<<5|b>>3]
+			bk.rgb = [3]int{r >> 3, g >> 3, b >> 3}
+			bk.count++
+			bk.sum[0] += r
+			bk.sum[1] += g
+			bk.sum[2] += b
+		}
+	}
+
+	var used []*bucket
+	for i := range buckets {
+		if buckets[i].count > 0 {
+			used = append(used, &buckets[i])
+		}
+	}
+	limit := maxPaletteColors
+	if transparent {
+		limit--
+	}
+	boxes := [][]*bucket{}
+	if len(used) > 0 {
+		boxes = append(boxes, used)
+	}
+	for len(boxes) < limit {
+		// the box with the widest channel is split at its median
+		widest, channel, width := -1, 0, 0
+		for n, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			for c := 0; c < 3; c++ {
+				low, high := box[0].rgb[c], box[0].rgb[c]
+				for _, bk := range box {
+					if bk.rgb[c] < low {
+						low = bk.rgb[c]
+					}
+					if bk.rgb[c] > high {
+						high = bk.rgb[c]
+					}
+				}
+				if high-low > width || widest == -1 {
+					widest, channel, width = n, c, high-low
+				}
+			}
+		}
+		if widest == -1 {
+			break
+		}
+		box := boxes[widest]
+		sort.Slice(box, func(i, j int) bool { return box[i].rgb[channel] < box[j].rgb[channel] })
+		total := 0
+		for _, bk := range box {
+			total += bk.count
+		}
+		split, count := 1, box[0].count
+		for split < len(box)-1 && count*2 < total {
+			count += box[split].count
+			split++
+		}
+		boxes[widest] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+
+	var colors color.Palette
+	if transparent || len(boxes) == 0 {
+		colors = append(colors, color.RGBA{})
+	}
+	for _, box := range boxes {
+		var count int
+		var sum [3]int
+		for _, bk := range box {
+			count += bk.count
+			for c := range sum {
+				sum[c] += bk.sum[c]
+			}
+		}
+		colors = append(colors, color.RGBA{
+			R: uint8(sum[0] / count),
+			G: uint8(sum[1] / count),
+			B: uint8(sum[2] / count),
+			A: 255,
+		})
+	}
+	return colors
+}
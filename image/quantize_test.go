@@ -0,0 +1,46 @@
+package image
+
+import (
+	"image"
+	"image/color"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuantize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	img.SetRGBA(0, 0, red)
+	img.SetRGBA(1, 0, blue)
+	img.SetRGBA(2, 0, red)
+	assert.Equal(t, color.Palette{transparent, blue, red}, sortedPalette(quantize(img)), "exact colors")
+
+	gradient := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			gradient.SetRGBA(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	colors := quantize(gradient)
+	assert.Equal(t, maxPaletteColors, len(colors))
+	for _, c := range colors {
+		assert.Equal(t, uint32(0xffff), alpha(c), "opaque image")
+	}
+}
+
+// sortedPalette orders colors by their components
+func sortedPalette(colors color.Palette) color.Palette {
+	key := func(c color.Color) uint32 {
+		r, g, b, _ := c.RGBA()
+		return r>>8<<16 | g>>8<<8 | b>>8
+	}
+	sorted := append(color.Palette{}, colors...)
+	sort.Slice(sorted, func(i, j int) bool { return key(sorted[i]) < key(sorted[j]) })
+	return sorted
+}
+
+func alpha(c color.Color) uint32 {
+	_, _, _, a := c.RGBA()
+	return a
+}
@@ -0,0 +1,177 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+
+	"github.com/h2non/bimg"
+)
+
+// maxRotatePixels limits the canvas of arbitrary angles, bigger images
+// are downscaled before rotating
+const maxRotatePixels = 16000000
+
+// parseAngle accepts flips, auto and angles in degrees clockwise, which
+// are normalised between 0 and 359
+func parseAngle(angle string) (string, error) {
+	switch angle {
+	case "auto", "hflip", "vflip":
+		return angle, nil
+	}
+	degrees, err := strconv.Atoi(angle)
+	if err != nil {
+		return "", fmt.Errorf("angle \"%s\" not allowed", angle)
+	}
+	return strconv.Itoa((degrees%360 + 360) % 360), nil
+}
+
+// rotate applies angle filter to every frame before cropping, so target
+// dimensions refer to the rotated image. Arbitrary angles enlarge the
+// image to keep it whole, filling corners with background.
+func (step *Step) rotate(frames [][]byte) error {
+	angle := step.Filters["angle"]
+	options := bimg.Options{Type: bimg.PNG}
+	switch angle {
+	case "", "0":
+		return nil
+	case "auto":
+		// exif orientation is always applied when images are processed
+		return nil
+	case "hflip":
+		options.Flop = true
+	case "vflip":
+		options.Flip = true
+	case "90", "180", "270":
+		degrees, _ := strconv.Atoi(angle)
+		options.Rotate = bimg.Angle(degrees)
+	default:
+		degrees, _ := strconv.Atoi(angle)
+		step.Source = Image{Content: bimg.NewImage(frames[0])}
+		if err := step.Source.ExtractInfo(); err != nil {
+			return err
+		}
+		background := step.background()
+		scale := rotateScale(step.Source.Width, step.Source.Height, float64(degrees))
+		for n := range frames {
+			rotated, err := rotateFree(frames[n], float64(degrees), scale, background)
+			if err != nil {
+				return err
+			}
+			frames[n] = rotated
+		}
+		return nil
+	}
+	for n := range frames {
+		// bimg ignores exif orientation when an angle is given
+		oriented, err := orient(frames[n])
+		if err != nil {
+			return err
+		}
+		if frames[n], err = bimg.NewImage(oriented).Process(options); err != nil {
+			return fmt.Errorf("can't rotate image: %v", err)
+		}
+	}
+	return nil
+}
+
+// orient applies exif orientation, returns a png
+func orient(buf []byte) ([]byte, error) {
+	buf, err := bimg.NewImage(buf).Process(bimg.Options{Type: bimg.PNG})
+	if err != nil {
+		return nil, fmt.Errorf("can't rotate image: %v", err)
+	}
+	return buf, nil
+}
+
+// rotateScale returns the factor which fits the rotation of an image of
+// width x height in maxRotatePixels, 1 if it already fits
+func rotateScale(width, height int, degrees float64) float64 {
+	dstWidth, dstHeight := rotatedSize(float64(width), float64(height), degrees)
+	pixels := float64(dstWidth) * float64(dstHeight)
+	if pixels <= maxRotatePixels {
+		return 1
+	}
+	return math.Sqrt(maxRotatePixels / pixels)
+}
+
+// rotateFree rotates buffer by any angle after scaling it, returns a png
+func rotateFree(buf []byte, degrees, scale float64, background bimg.Color) ([]byte, error) {
+	// bimg decodes formats not in stdlib
+	buf, err := orient(buf)
+	if err != nil {
+		return nil, err
+	}
+	if scale < 1 {
+		size, err := bimg.NewImage(buf).Size()
+		if err != nil {
+			return nil, fmt.Errorf("can't rotate image: %v", err)
+		}
+		options := bimg.Options{Width: int(float64(size.Width) * scale), Type: bimg.PNG}
+		if buf, err = bimg.NewImage(buf).Process(options); err != nil {
+			return nil, fmt.Errorf("can't rotate image: %v", err)
+		}
+	}
+	src, err := decodeRGBA(buf)
+	if err != nil {
+		return nil, fmt.Errorf("can't rotate image: %v", err)
+	}
+	bg := color.RGBA{R: background.R, G: background.G, B: background.B, A: 255}
+	return encodePNG(rotateRGBA(src, degrees, bg))
+}
+
+// rotateRGBA rotates clockwise around the center. Output is big enough to
+// contain the whole rotated image, pixels are interpolated bilinearly.
+func rotateRGBA(src *image.RGBA, degrees float64, bg color.RGBA) *image.RGBA {
+	rad := degrees * math.Pi / 180
+	sin, cos := math.Sin(rad), math.Cos(rad)
+	width, height := float64(src.Rect.Dx()), float64(src.Rect.Dy())
+	dstWidth, dstHeight := rotatedSize(width, height, degrees)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		for x := 0; x < dstWidth; x++ {
+			// pixel centers relative to the center of the output
+			dx := float64(x) + 0.5 - float64(dstWidth)/2
+			dy := float64(y) + 0.5 - float64(dstHeight)/2
+			sx := cos*dx + sin*dy + width/2 - 0.5
+			sy := -sin*dx + cos*dy + height/2 - 0.5
+			dst.SetRGBA(x, y, bilinear(src, sx, sy, bg))
+		}
+	}
+	return dst
+}
+
+// rotatedSize returns the dimensions containing width x height rotated
+func rotatedSize(width, height, degrees float64) (int, int) {
+	rad := degrees * math.Pi / 180
+	sin, cos := math.Sin(rad), math.Cos(rad)
+	dstWidth := int(math.Ceil(math.Abs(width*cos) + math.Abs(height*sin) - 1e-6))
+	dstHeight := int(math.Ceil(math.Abs(width*sin) + math.Abs(height*cos) - 1e-6))
+	return dstWidth, dstHeight
+}
+
+// bilinear interpolates the color at x, y. Pixels outside of the image
+// take background color, so edges are smoothed.
+func bilinear(img *image.RGBA, x, y float64, bg color.RGBA) color.RGBA {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	left, top := img.Rect.Min.X+int(x0), img.Rect.Min.Y+int(y0)
+	points := []image.Point{{left, top}, {left + 1, top}, {left, top + 1}, {left + 1, top + 1}}
+	weights := []float64{(1 - fx) * (1 - fy), fx * (1 - fy), (1 - fx) * fy, fx * fy}
+
+	var r, g, b, a float64
+	for i, p := range points {
+		c := bg
+		if p.In(img.Rect) {
+			c = img.RGBAAt(p.X, p.Y)
+		}
+		r += weights[i] * float64(c.R)
+		g += weights[i] * float64(c.G)
+		b += weights[i] * float64(c.B)
+		a += weights[i] * float64(c.A)
+	}
+	return color.RGBA{R: uint8(r + 0.5), G: uint8(g + 0.5), B: uint8(b + 0.5), A: uint8(a + 0.5)}
+}
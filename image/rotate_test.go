@@ -0,0 +1,121 @@
+package image
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+
+	"github.com/h2non/bimg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAngle(t *testing.T) {
+	cases := []struct {
+		angle       string
+		expected    string
+		err         error
+		description string
+	}{
+		{"90", "90", nil, "right angle"},
+		{"450", "90", nil, "more than a turn"},
+		{"-90", "270", nil, "counterclockwise"},
+		{"45", "45", nil, "arbitrary angle"},
+		{"hflip", "hflip", nil, "horizontal flip"},
+		{"vflip", "vflip", nil, "vertical flip"},
+		{"auto", "auto", nil, "exif orientation"},
+		{"fake", "", errors.New("angle \"fake\" not allowed"), "not accepted"},
+	}
+	for _, test := range cases {
+		angle, err := parseAngle(test.angle)
+		assert.Equal(t, test.err, err, test.description)
+		assert.Equal(t, test.expected, angle, test.description)
+	}
+}
+
+func TestRotateRGBA(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	src.SetRGBA(0, 0, red)
+
+	rotated := rotateRGBA(src, 90, white)
+	assert.Equal(t, image.Rect(0, 0, 2, 4), rotated.Rect)
+	assert.Equal(t, red, rotated.RGBAAt(1, 0), "top left goes to top right")
+
+	rotated = rotateRGBA(image.NewRGBA(image.Rect(0, 0, 100, 100)), 45, white)
+	assert.Equal(t, image.Rect(0, 0, 142, 142), rotated.Rect)
+	assert.Equal(t, white, rotated.RGBAAt(0, 0), "corners are filled with background")
+}
+
+func TestRotateScale(t *testing.T) {
+	assert.Equal(t, 1.0, rotateScale(1262, 733, 30), "fits")
+	assert.Equal(t, 1.0, rotateScale(4000, 4000, 0), "fits without angle")
+
+	scale := rotateScale(4000, 4000, 45)
+	assert.True(t, scale < 1, "downscaled")
+	width, height := rotatedSize(4000*scale, 4000*scale, 45)
+	assert.True(t, width*height <= maxRotatePixels, "rotation fits")
+	assert.True(t, width*height > maxRotatePixels*99/100, "rotation is not smaller than needed")
+}
+
+func TestJobProcessRotateLimit(t *testing.T) {
+	src, err := encodePNG(opaque(5000, 4000))
+	assert.Nil(t, err)
+	job := NewJob()
+	assert.Nil(t, job.parseFilters("a_45,f_png"))
+	job.Source.Content = bimg.NewImage(src)
+
+	assert.Nil(t, job.Process(nil))
+	size, _ := bimg.NewImage(job.Target.RawContent).Size()
+	assert.True(t, size.Width*size.Height <= maxRotatePixels, "downscaled before rotating")
+	assert.True(t, size.Width > 3000 && size.Width == size.Height, "whole rotated image")
+}
+
+func TestJobProcessRotate(t *testing.T) {
+	cases := []struct {
+		filters     string
+		width       int
+		height      int
+		description string
+	}{
+		{"a_90,f_png", 733, 1262, "right angle"},
+		{"a_hflip,f_png", 1262, 733, "flip"},
+		{"a_90,w_100,c_fit,f_png", 100, 172, "width of rotated image"},
+	}
+	for _, test := range cases {
+		job := NewJob()
+		assert.Nil(t, job.parseFilters(test.filters), test.description)
+		r, _ := os.Open("testdata/fiveyears.jpg")
+		job.Source.Load(r)
+
+		assert.Nil(t, job.Process(nil), test.description)
+		size, _ := bimg.NewImage(job.Target.RawContent).Size()
+		assert.Equal(t, test.width, size.Width, test.description)
+		assert.Equal(t, test.height, size.Height, test.description)
+	}
+}
+
+func TestJobProcessRotateOriented(t *testing.T) {
+	cases := []struct {
+		filters     string
+		width       int
+		height      int
+		description string
+	}{
+		{"a_90,f_png", 40, 20, "right angle over exif orientation"},
+		{"a_30,b_auto,f_png", 38, 45, "free angle over exif orientation"},
+	}
+	for _, test := range cases {
+		job := NewJob()
+		assert.Nil(t, job.parseFilters(test.filters), test.description)
+		r, _ := os.Open("testdata/orientation.jpg")
+		job.Source.Load(r)
+
+		assert.Nil(t, job.Process(nil), test.description)
+		size, _ := bimg.NewImage(job.Target.RawContent).Size()
+		assert.Equal(t, test.width, size.Width, test.description)
+		assert.Equal(t, test.height, size.Height, test.description)
+	}
+}
@@ -0,0 +1,113 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+
+	"github.com/h2non/bimg"
+)
+
+// roundMax is the radius of r_max, an ellipse inscribed in the image
+const roundMax = -1
+
+// alphaFormats can keep transparent corners, the rest are flattened over
+// background
+var alphaFormats = map[bimg.ImageType]bool{
+	bimg.PNG:  true,
+	bimg.WEBP: true,
+	bimg.GIF:  true,
+	bimg.TIFF: true,
+	bimg.AVIF: true,
+	bimg.HEIF: true,
+}
+
+// parseRadius accepts radius in pixels or max
+func parseRadius(s string) (int, error) {
+	if s == "max" {
+		return roundMax, nil
+	}
+	radius, err := strconv.Atoi(s)
+	if err != nil || radius < 0 {
+		return 0, fmt.Errorf("radius \"%s\" not allowed", s)
+	}
+	return radius, nil
+}
+
+// radiusString returns the radius as accepted by parseRadius
+func radiusString(radius int) string {
+	if radius == roundMax {
+		return "max"
+	}
+	return strconv.Itoa(radius)
+}
+
+// round makes corners of a png buffer transparent and encodes it with
+// given format, flattening over background if the output format has no
+// alpha. Given format may be a lossless one encoded later.
+func (img *Image) round(buf []byte, format bimg.ImageType) ([]byte, error) {
+	pixels, err := decodeRGBA(buf)
+	if err != nil {
+		return nil, fmt.Errorf("can't round corners: %v", err)
+	}
+	mask(pixels, img.Radius)
+	output := img.output
+	if output == bimg.UNKNOWN {
+		output = img.Format
+	}
+	if !alphaFormats[output] {
+		flatten(pixels, img.Background)
+	}
+	if buf, err = encodePNG(pixels); err != nil {
+		return nil, fmt.Errorf("can't round corners: %v", err)
+	}
+	return bimg.NewImage(buf).Process(bimg.Options{Type: format, Quality: img.Quality})
+}
+
+// mask scales pixels by their coverage of a rounded rectangle, or of the
+// inscribed ellipse for roundMax. Edges are antialiased.
+func mask(img *image.RGBA, radius int) {
+	width, height := float64(img.Rect.Dx()), float64(img.Rect.Dy())
+	r := math.Min(float64(radius), math.Min(width, height)/2)
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+			var coverage float64
+			if radius == roundMax {
+				coverage = ellipseCoverage(px-width/2, py-height/2, width/2, height/2)
+			} else {
+				// distance to the nearest corner center, zero out of corners
+				dx := math.Max(math.Max(r-px, px-(width-r)), 0)
+				dy := math.Max(math.Max(r-py, py-(height-r)), 0)
+				coverage = r - math.Hypot(dx, dy) + 0.5
+			}
+			if coverage >= 1 {
+				continue
+			}
+			i := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			for c := 0; c < 4; c++ {
+				img.Pix[i+c] = uint8(float64(img.Pix[i+c])*math.Max(coverage, 0) + 0.5)
+			}
+		}
+	}
+}
+
+// ellipseCoverage approximates the coverage of a pixel centered at x, y
+// relative to the center of an ellipse with a, b semiaxes
+func ellipseCoverage(x, y, a, b float64) float64 {
+	v := math.Hypot(x/a, y/b)
+	return (1-v)*math.Min(a, b) + 0.5
+}
+
+// flatten composes the image over an opaque background
+func flatten(img *image.RGBA, background bimg.Color) {
+	bg := []float64{float64(background.R), float64(background.G), float64(background.B)}
+	for i := 0; i < len(img.Pix); i += 4 {
+		transparency := 1 - float64(img.Pix[i+3])/255
+		for c := 0; c < 3; c++ {
+			img.Pix[i+c] = uint8(float64(img.Pix[i+c]) + bg[c]*transparency + 0.5)
+		}
+		img.Pix[i+3] = 255
+	}
+}
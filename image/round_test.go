@@ -0,0 +1,140 @@
+package image
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"testing"
+
+	"github.com/h2non/bimg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRadius(t *testing.T) {
+	cases := []struct {
+		radius      string
+		expected    int
+		err         error
+		description string
+	}{
+		{"20", 20, nil, "pixels"},
+		{"max", roundMax, nil, "ellipse"},
+		{"-5", 0, errors.New("radius \"-5\" not allowed"), "negative"},
+		{"fake", 0, errors.New("radius \"fake\" not allowed"), "not accepted"},
+	}
+	for _, test := range cases {
+		radius, err := parseRadius(test.radius)
+		assert.Equal(t, test.err, err, test.description)
+		assert.Equal(t, test.expected, radius, test.description)
+		if err == nil {
+			assert.Equal(t, test.radius, radiusString(radius), test.description)
+		}
+	}
+}
+
+// opaque builds a white image without transparency
+func opaque(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Rect, image.White, image.Point{}, draw.Src)
+	return img
+}
+
+func TestMask(t *testing.T) {
+	img := opaque(40, 20)
+	mask(img, 10)
+	assert.Equal(t, uint8(0), img.RGBAAt(0, 0).A, "corner is transparent")
+	assert.Equal(t, uint8(255), img.RGBAAt(20, 0).A, "edge is kept")
+	assert.Equal(t, uint8(255), img.RGBAAt(20, 10).A, "center is kept")
+
+	img = opaque(40, 20)
+	mask(img, roundMax)
+	assert.Equal(t, uint8(0), img.RGBAAt(2, 2).A, "out of the ellipse")
+	assert.Equal(t, uint8(255), img.RGBAAt(20, 10).A, "center is kept")
+	assert.Equal(t, uint8(255), img.RGBAAt(1, 10).A, "ellipse touches the edges")
+
+	img = opaque(20, 20)
+	mask(img, 100)
+	assert.Equal(t, uint8(0), img.RGBAAt(1, 1).A, "radius is limited to a circle")
+	assert.Equal(t, uint8(255), img.RGBAAt(10, 10).A, "radius is limited to a circle")
+}
+
+func TestFlatten(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(1, 0, color.RGBA{R: 128, A: 128})
+	flatten(img, bimg.Color{R: 0, G: 0, B: 255})
+	assert.Equal(t, color.RGBA{B: 255, A: 255}, img.RGBAAt(0, 0), "transparent is background")
+	assert.Equal(t, color.RGBA{R: 128, B: 127, A: 255}, img.RGBAAt(1, 0), "half transparent is blended")
+}
+
+func TestProcessRound(t *testing.T) {
+	cases := []struct {
+		format      bimg.ImageType
+		corner      color.RGBA
+		description string
+	}{
+		{bimg.PNG, color.RGBA{}, "transparent corners"},
+		{bimg.JPEG, color.RGBA{R: 255, G: 255, B: 255, A: 255}, "background corners"},
+	}
+	for _, test := range cases {
+		source := Image{}
+		r, _ := os.Open("testdata/fiveyears.jpg")
+		source.Load(r)
+		img := Image{Width: 200, Height: 200, Format: test.format, Radius: roundMax, Background: white}
+
+		err := img.Process(source, nil)
+		assert.Nil(t, err, test.description)
+		png, _ := bimg.NewImage(img.RawContent).Convert(bimg.PNG)
+		out, _ := decodeRGBA(png)
+		c := out.RGBAAt(0, 0)
+		assert.Equal(t, test.corner.A, c.A, test.description)
+		assert.True(t, int(c.R) >= int(test.corner.R)-5 && int(c.B) >= int(test.corner.B)-5, test.description)
+	}
+}
+
+func TestJobProcessRoundAutoQuality(t *testing.T) {
+	for _, filters := range []string{"q_auto", "fl_maxbytes:20000"} {
+		job := NewJob()
+		err := job.parseFilters("w_200,h_200,c_fill,r_max,f_jpg," + filters)
+		assert.Nil(t, err, filters)
+		r, _ := os.Open("testdata/fiveyears.jpg")
+		job.Source.Load(r)
+
+		err = job.Process(nil)
+		assert.Nil(t, err, filters)
+		assert.Equal(t, "jpeg", bimg.DetermineImageTypeName(job.Target.RawContent), filters)
+		png, _ := bimg.NewImage(job.Target.RawContent).Convert(bimg.PNG)
+		out, _ := decodeRGBA(png)
+		c := out.RGBAAt(0, 0)
+		assert.True(t, c.R >= 250 && c.G >= 250 && c.B >= 250, "white corner with "+filters)
+	}
+}
+
+func TestJobProcessRoundChained(t *testing.T) {
+	cases := []struct {
+		filters     string
+		corner      color.RGBA
+		description string
+	}{
+		{"w_200,h_200,c_fill,r_max/e_blur,f_jpg", color.RGBA{R: 255, G: 255, B: 255, A: 255}, "white corner"},
+		{"w_200,h_200,c_fill,r_max,b_blue/w_100,f_jpg", color.RGBA{B: 255, A: 255}, "background corner"},
+		{"w_200,h_200,c_fill,r_max/w_100,f_png", color.RGBA{}, "transparent corner"},
+	}
+	for _, test := range cases {
+		job := NewJob()
+		err := job.parseFilters(test.filters)
+		assert.Nil(t, err, test.description)
+		r, _ := os.Open("testdata/fiveyears.jpg")
+		job.Source.Load(r)
+
+		err = job.Process(nil)
+		assert.Nil(t, err, test.description)
+		png, _ := bimg.NewImage(job.Target.RawContent).Convert(bimg.PNG)
+		out, _ := decodeRGBA(png)
+		c := out.RGBAAt(0, 0)
+		assert.Equal(t, test.corner.A, c.A, test.description)
+		assert.True(t, int(c.R) >= int(test.corner.R)-10 && int(c.G) >= int(test.corner.G)-10 && int(c.B) >= int(test.corner.B)-10, test.description)
+		assert.True(t, int(c.R) <= int(test.corner.R)+10 && int(c.G) <= int(test.corner.G)+10 && int(c.B) <= int(test.corner.B)+10, test.description)
+	}
+}
@@ -0,0 +1,133 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+
+	"github.com/h2non/bimg"
+)
+
+// sampleSize is the maximum dimension of the images used for analysis
+const sampleSize = 256
+
+// sample decodes a downscaled copy of the image content into a go image,
+// returning the scale factor between original and sample pixels
+func (img *Image) sample(size int) (image.Image, float64, error) {
+	if img.Content == nil || img.Width == 0 || img.Height == 0 {
+		return nil, 0, fmt.Errorf("image info not extracted")
+	}
+	factor := math.Max(float64(img.Width), float64(img.Height)) / float64(size)
+	if factor < 1 {
+		factor = 1
+	}
+	options := bimg.Options{
+		Width:  int(float64(img.Width) / factor),
+		Height: int(float64(img.Height) / factor),
+		Type:   bimg.PNG,
+	}
+	buf, err := bimg.Resize(img.Content.Image(), options)
+	if err != nil {
+		return nil, 0, fmt.Errorf("can't sample image: %v", err)
+	}
+	sample, err := png.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, 0, fmt.Errorf("can't decode sample: %v", err)
+	}
+	return sample, factor, nil
+}
+
+// grayscale converts any image to luminance values
+func grayscale(src image.Image) *image.Gray {
+	bounds := src.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(gray, gray.Bounds(), src, bounds.Min, draw.Src)
+	return gray
+}
+
+// entropy computes shannon entropy of the luminance histogram in rect
+func entropy(gray *image.Gray, rect image.Rectangle) float64 {
+	var histogram [256]int
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		row := gray.Pix[y*gray.Stride:]
+		for _, v := range row[rect.Min.X:rect.Max.X] {
+			histogram[v]++
+		}
+	}
+	total := float64(rect.Dx() * rect.Dy())
+	var e float64
+	for _, n := range histogram {
+		if n > 0 {
+			p := float64(n) / total
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
+
+// entropyWindow slides a window of width x height over the image and
+// returns the position with the highest entropy. Ties keep the window
+// closer to the center.
+func entropyWindow(gray *image.Gray, width, height int) (int, int) {
+	bounds := gray.Bounds()
+	freeX, freeY := bounds.Dx()-width, bounds.Dy()-height
+	if freeX < 0 {
+		freeX = 0
+	}
+	if freeY < 0 {
+		freeY = 0
+	}
+	step := int(math.Max(float64(freeX), float64(freeY)) / 16)
+	if step < 1 {
+		step = 1
+	}
+
+	bestX, bestY := freeX/2, freeY/2
+	best := entropy(gray, image.Rect(bestX, bestY, bestX+width, bestY+height).Intersect(bounds))
+	for y := 0; y <= freeY; y += step {
+		for x := 0; x <= freeX; x += step {
+			rect := image.Rect(x, y, x+width, y+height).Intersect(bounds)
+			if e := entropy(gray, rect); e > best {
+				best, bestX, bestY = e, x, y
+			}
+		}
+	}
+	return bestX, bestY
+}
+
+// interestingArea returns the area of the given dimensions with more
+// details, measured as the entropy of its luminance
+func (img *Image) interestingArea(width, height int) (*Area, error) {
+	sample, factor, err := img.sample(sampleSize)
+	if err != nil {
+		return nil, err
+	}
+	x, y := entropyWindow(grayscale(sample), int(float64(width)/factor), int(float64(height)/factor))
+	area := &Area{
+		Left:   int(float64(x) * factor),
+		Top:    int(float64(y) * factor),
+		Width:  width,
+		Height: height,
+	}
+	return area.fit(img.Width, img.Height), nil
+}
+
+// fit moves the area to keep it inside an image of given dimensions
+func (area *Area) fit(width, height int) *Area {
+	if area.Left+area.Width > width {
+		area.Left = width - area.Width
+	}
+	if area.Top+area.Height > height {
+		area.Top = height - area.Height
+	}
+	if area.Left < 0 {
+		area.Left = 0
+	}
+	if area.Top < 0 {
+		area.Top = 0
+	}
+	return area
+}
@@ -0,0 +1,56 @@
+package image
+
+import (
+	"image"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// noisyGray builds an image flat at the left and with details at the right
+func noisyGray(width, height, flat int) *image.Gray {
+	gray := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := flat; x < width; x++ {
+			gray.Pix[y*gray.Stride+x] = uint8((x*31 + y*17) % 256)
+		}
+	}
+	return gray
+}
+
+func TestEntropy(t *testing.T) {
+	gray := noisyGray(100, 50, 50)
+	assert.Equal(t, 0.0, entropy(gray, image.Rect(0, 0, 50, 50)), "flat area")
+	assert.True(t, entropy(gray, image.Rect(50, 0, 100, 50)) > 0, "noisy area")
+}
+
+func TestEntropyWindow(t *testing.T) {
+	gray := noisyGray(200, 50, 100)
+	x, y := entropyWindow(gray, 50, 50)
+	assert.True(t, x >= 100, "window should be in the noisy half")
+	assert.Equal(t, 0, y)
+
+	x, y = entropyWindow(image.NewGray(image.Rect(0, 0, 200, 50)), 50, 50)
+	assert.Equal(t, 75, x, "flat image keeps center")
+	assert.Equal(t, 0, y)
+}
+
+func TestAreaFit(t *testing.T) {
+	area := &Area{Left: 80, Top: -10, Width: 50, Height: 50}
+	assert.Equal(t, &Area{Left: 50, Top: 0, Width: 50, Height: 50}, area.fit(100, 100))
+}
+
+func TestInterestingArea(t *testing.T) {
+	img := Image{}
+	r, _ := os.Open("testdata/fiveyears.jpg")
+	img.Load(r)
+	img.ExtractInfo()
+
+	area, err := img.interestingArea(400, 733)
+	assert.Nil(t, err)
+	assert.Equal(t, 400, area.Width)
+	assert.Equal(t, 733, area.Height)
+	assert.Equal(t, 0, area.Top)
+	assert.True(t, area.Left >= 0 && area.Left+area.Width <= img.Width)
+}
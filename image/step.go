@@ -0,0 +1,308 @@
+package image
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+// Step is a component of a chained transformation. Its source is the
+// output of the previous step.
+type Step struct {
+	Source  Image
+	Target  Image
+	Filters map[string]string
+	// relative dimensions are fractions of the source size
+	RelativeWidth  float64
+	RelativeHeight float64
+	// AutoWidth is set when width comes from client hints
+	AutoWidth bool
+}
+
+// newStep constructs a step with default filters
+func newStep() *Step {
+	step := &Step{Filters: make(map[string]string)}
+	step.Filters["crop"] = "scale"
+	return step
+}
+
+// canonical returns step filters ordered by name
+func (step *Step) canonical() string {
+	filters := []string{
+		"w_" + strconv.Itoa(step.Target.Width),
+		"h_" + strconv.Itoa(step.Target.Height),
+	}
+	if step.RelativeWidth > 0 {
+		filters[0] = "w_" + strconv.FormatFloat(step.RelativeWidth, 'f', -1, 64)
+	}
+	if step.AutoWidth {
+		filters[0] = "w_auto:" + strconv.Itoa(step.Target.Width)
+	}
+	if step.RelativeHeight > 0 {
+		filters[1] = "h_" + strconv.FormatFloat(step.RelativeHeight, 'f', -1, 64)
+	}
+	for name, value := range step.Filters {
+		filters = append(filters, name+"_"+value)
+	}
+	if step.Target.Effect != nil {
+		filters = append(filters, "e_"+step.Target.Effect.String())
+	}
+	if step.Target.Radius != 0 {
+		filters = append(filters, "r_"+radiusString(step.Target.Radius))
+	}
+	sort.Strings(filters)
+	return strings.Join(filters, ",")
+}
+
+// resolve converts relative dimensions to pixels of the source and
+// multiplies dimensions, offsets and radius by device pixel ratio. Widths
+// from client hints are already in device pixels.
+func (step *Step) resolve(dpr float64) {
+	if step.RelativeWidth > 0 {
+		step.Target.Width = int(math.Round(float64(step.Source.Width) * step.RelativeWidth))
+	}
+	if step.RelativeHeight > 0 {
+		step.Target.Height = int(math.Round(float64(step.Source.Height) * step.RelativeHeight))
+	}
+	if dpr <= 0 || dpr == 1 {
+		return
+	}
+	scale := func(v int) int {
+		return int(math.Round(float64(v) * dpr))
+	}
+	if !step.AutoWidth {
+		step.Target.Width = scale(step.Target.Width)
+	}
+	step.Target.Height = scale(step.Target.Height)
+	if step.Target.Radius > 0 {
+		step.Target.Radius = scale(step.Target.Radius)
+	}
+	for _, name := range []string{"x", "y"} {
+		if v, err := strconv.Atoi(step.Filters[name]); err == nil {
+			step.Filters[name] = strconv.Itoa(scale(v))
+		}
+	}
+}
+
+// Crop calculates the best strategy to crop the image
+func (step *Step) Crop() error {
+
+	// reset dimensions
+	switch step.Filters["crop"] {
+	// Preserve aspect ratio, bigger dimension is selected
+	case "fit":
+		if step.Target.Height > step.Target.Width {
+			step.Target.Width = int(float32(step.Target.Height) * step.Source.AspectRatio)
+		} else {
+			step.Target.Height = int(float32(step.Target.Width) / step.Source.AspectRatio)
+		}
+	// Same as Fit but limiting size to original image
+	case "limit":
+		if step.Target.Height > step.Source.Height || step.Target.Width > step.Source.Width {
+			step.Target.Width = step.Source.Width
+			step.Target.Height = step.Source.Height
+		} else {
+			if step.Target.Height > step.Target.Width {
+				step.Target.Width = int(float32(step.Target.Height) * step.Source.AspectRatio)
+			} else {
+				step.Target.Height = int(float32(step.Target.Width) / step.Source.AspectRatio)
+			}
+		}
+	// do not preserve nothing, respect callers decision
+	case "scale":
+		if step.Target.Width == 0 {
+			step.Target.Width = step.Target.Height
+		}
+		if step.Target.Height == 0 {
+			step.Target.Height = step.Target.Width
+		}
+	// Exact dimensions, covering them and cutting the rest following gravity
+	case "fill":
+		step.fill(true)
+	// Same as fill but limiting size to original image
+	case "thumb":
+		step.fill(false)
+	// Extract region from original image without resizing
+	case "crop":
+		step.crop()
+	// Fit inside target dimensions filling the rest with background
+	case "pad":
+		step.pad(true)
+	// Same as pad but limiting size to original image
+	case "lpad":
+		step.pad(false)
+	}
+
+	// rounded corners are flattened over background without alpha
+	if step.Target.Radius != 0 && step.Target.Embed == nil {
+		step.Target.Background = step.background()
+	}
+	return nil
+}
+
+// pad resizes the source to fit inside target dimensions and places it
+// over a canvas of target dimensions following gravity
+func (step *Step) pad(enlarge bool) {
+	if step.Source.Width == 0 || step.Source.Height == 0 {
+		return
+	}
+	if step.Target.Width == 0 && step.Target.Height == 0 {
+		return
+	}
+	if step.Target.Width == 0 {
+		step.Target.Width = atLeastOne(int(float32(step.Target.Height) * step.Source.AspectRatio))
+	}
+	if step.Target.Height == 0 {
+		step.Target.Height = atLeastOne(int(float32(step.Target.Width) / step.Source.AspectRatio))
+	}
+
+	width, height := step.Target.Width, int(float32(step.Target.Width)/step.Source.AspectRatio)
+	if height > step.Target.Height {
+		width, height = int(float32(step.Target.Height)*step.Source.AspectRatio), step.Target.Height
+	}
+	if !enlarge && (width > step.Source.Width || height > step.Source.Height) {
+		width, height = step.Source.Width, step.Source.Height
+	}
+	step.Target.Embed = locate(step.Filters["gravity"], step.Target.Width, step.Target.Height, atLeastOne(width), atLeastOne(height))
+	step.Target.Background = step.background()
+}
+
+// background resolves the background filter, white by default
+func (step *Step) background() bimg.Color {
+	switch step.Filters["background"] {
+	case "":
+		return white
+	case "auto":
+		c, err := step.Source.edgeColor()
+		if err != nil {
+			log.Printf("can't detect background, using white: %v", err)
+		}
+		return c
+	default:
+		c, _ := parseColor(step.Filters["background"])
+		return c
+	}
+}
+
+// crop selects the region given by x, y and target dimensions. Missing
+// coordinates are computed following gravity.
+func (step *Step) crop() {
+	if step.Source.Width == 0 || step.Source.Height == 0 {
+		return
+	}
+	if step.Target.Width == 0 || step.Target.Width > step.Source.Width {
+		step.Target.Width = step.Source.Width
+	}
+	if step.Target.Height == 0 || step.Target.Height > step.Source.Height {
+		step.Target.Height = step.Source.Height
+	}
+
+	x, errX := strconv.Atoi(step.Filters["x"])
+	y, errY := strconv.Atoi(step.Filters["y"])
+	area := &Area{Left: x, Top: y, Width: step.Target.Width, Height: step.Target.Height}
+	if errX != nil || errY != nil {
+		placed := step.place(area.Width, area.Height)
+		if errX != nil {
+			area.Left = placed.Left
+		}
+		if errY != nil {
+			area.Top = placed.Top
+		}
+	}
+	step.Target.Extract = area.fit(step.Source.Width, step.Source.Height)
+}
+
+// fill selects the biggest area of the source with the same aspect ratio
+// as the target. The area is resized later to target dimensions.
+func (step *Step) fill(enlarge bool) {
+	if step.Source.Width == 0 || step.Source.Height == 0 {
+		return
+	}
+	if step.Target.Width == 0 && step.Target.Height == 0 {
+		return
+	}
+	if step.Target.Width == 0 {
+		step.Target.Width = atLeastOne(int(float32(step.Target.Height) * step.Source.AspectRatio))
+	}
+	if step.Target.Height == 0 {
+		step.Target.Height = atLeastOne(int(float32(step.Target.Width) / step.Source.AspectRatio))
+	}
+	// both dimensions shrink by the same factor to keep the aspect ratio
+	if !enlarge {
+		factor := math.Min(
+			float64(step.Source.Width)/float64(step.Target.Width),
+			float64(step.Source.Height)/float64(step.Target.Height),
+		)
+		if factor < 1 {
+			step.Target.Width = atLeastOne(int(math.Round(float64(step.Target.Width) * factor)))
+			step.Target.Height = atLeastOne(int(math.Round(float64(step.Target.Height) * factor)))
+		}
+	}
+
+	ratio := float32(step.Target.Width) / float32(step.Target.Height)
+	width, height := step.Source.Width, int(float32(step.Source.Width)/ratio)
+	if height > step.Source.Height {
+		width, height = int(float32(step.Source.Height)*ratio), step.Source.Height
+	}
+	step.Target.Extract = step.place(atLeastOne(width), atLeastOne(height))
+}
+
+// atLeastOne avoids empty dimensions computed from extreme aspect ratios
+func atLeastOne(v int) int {
+	if v < 1 {
+		return 1
+	}
+	return v
+}
+
+// place locates an area of the given dimensions inside the source image
+// according to gravity filter
+func (step *Step) place(width, height int) *Area {
+	var area *Area
+	var err error
+
+	gravity := step.Filters["gravity"]
+	switch gravity {
+	case "auto":
+		area, err = step.Source.interestingArea(width, height)
+	case "face":
+		area, err = step.Source.faceArea(width, height, false)
+	case "faces":
+		area, err = step.Source.faceArea(width, height, true)
+	}
+	if area != nil {
+		return area
+	}
+	if err != nil {
+		log.Printf("can't apply gravity %s, using center: %v", gravity, err)
+	}
+	return locate(gravity, step.Source.Width, step.Source.Height, width, height)
+}
+
+// locate places an area of width x height inside a container following
+// compass gravities, any other gravity is centered
+func locate(gravity string, containerWidth, containerHeight, width, height int) *Area {
+	area := &Area{
+		Left:   (containerWidth - width) / 2,
+		Top:    (containerHeight - height) / 2,
+		Width:  width,
+		Height: height,
+	}
+	if strings.HasPrefix(gravity, "north") {
+		area.Top = 0
+	}
+	if strings.HasPrefix(gravity, "south") {
+		area.Top = containerHeight - height
+	}
+	if strings.HasSuffix(gravity, "west") {
+		area.Left = 0
+	}
+	if strings.HasSuffix(gravity, "east") {
+		area.Left = containerWidth - width
+	}
+	return area
+}
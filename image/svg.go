@@ -0,0 +1,126 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// svgSize reads the intrinsic dimensions of a svg from its width and
+// height attributes or, if missing or relative, from its viewBox
+func svgSize(buf []byte) (float64, float64, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(buf))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return 0, 0, fmt.Errorf("can't parse svg: %v", err)
+		}
+		root, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if root.Name.Local != "svg" {
+			return 0, 0, fmt.Errorf("can't parse svg: root element is %s", root.Name.Local)
+		}
+
+		var width, height float64
+		var viewBox []string
+		for _, attr := range root.Attr {
+			switch attr.Name.Local {
+			case "width":
+				width = svgLength(attr.Value)
+			case "height":
+				height = svgLength(attr.Value)
+			case "viewBox":
+				viewBox = strings.Fields(strings.Replace(attr.Value, ",", " ", -1))
+			}
+		}
+		if (width == 0 || height == 0) && len(viewBox) == 4 {
+			width, _ = strconv.ParseFloat(viewBox[2], 64)
+			height, _ = strconv.ParseFloat(viewBox[3], 64)
+		}
+		if width <= 0 || height <= 0 {
+			return 0, 0, fmt.Errorf("can't parse svg: dimensions not found")
+		}
+		return width, height, nil
+	}
+}
+
+// svgLength converts absolute lengths to pixels, 0 if not absolute
+func svgLength(s string) float64 {
+	units := map[string]float64{
+		"px": 1,
+		"pt": 4.0 / 3,
+		"pc": 16,
+		"in": 96,
+		"cm": 96 / 2.54,
+		"mm": 96 / 25.4,
+	}
+	s = strings.TrimSpace(s)
+	factor := 1.0
+	for unit, f := range units {
+		if strings.HasSuffix(s, unit) {
+			s, factor = strings.TrimSuffix(s, unit), f
+			break
+		}
+	}
+	length, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return length * factor
+}
+
+// maxSVGPixels limits the size of rasterized svgs, bigger ones are scaled
+// down to it
+const maxSVGPixels = 25000000
+
+// svgScale returns the scale needed to cover given dimensions, 1 if
+// there is no dimension to cover. Density, in dpi, counts for the limit
+// of rendered pixels.
+func svgScale(buf []byte, width, height, density int) (float64, error) {
+	svgWidth, svgHeight, err := svgSize(buf)
+	if err != nil {
+		if width == 0 && height == 0 {
+			// librsvg picks a default size
+			return 1, nil
+		}
+		return 0, err
+	}
+	scale := 1.0
+	if width > 0 || height > 0 {
+		scale = math.Max(float64(width)/svgWidth, float64(height)/svgHeight)
+	}
+	dpi := 72.0
+	if density > 0 {
+		dpi = float64(density)
+	}
+	pixels := svgWidth * svgHeight * math.Pow(scale*dpi/72, 2)
+	if pixels > maxSVGPixels {
+		scale *= math.Sqrt(maxSVGPixels / pixels)
+	}
+	return scale, nil
+}
+
+// renderSVG rasterizes a svg as png covering given dimensions, so it is
+// never enlarged later. Density, in dpi, multiplies the size.
+func renderSVG(ctx context.Context, buf []byte, width, height, density int) ([]byte, error) {
+	scale, err := svgScale(buf, width, height, density)
+	if err != nil {
+		return nil, err
+	}
+	input := fmt.Sprintf("input.svg[scale=%g", scale)
+	if density > 0 {
+		input += fmt.Sprintf(",dpi=%d", density)
+	}
+	input += "]"
+	out, err := vipsCopy(ctx, buf, input, "output.png")
+	if err != nil {
+		return nil, fmt.Errorf("can't render svg: %v", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,75 @@
+package image
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/h2non/bimg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSVGSize(t *testing.T) {
+	cases := []struct {
+		svg         string
+		width       float64
+		height      float64
+		description string
+	}{
+		{`<svg width="100" height="50"></svg>`, 100, 50, "width and height"},
+		{`<svg width="100px" height="3in"></svg>`, 100, 288, "units"},
+		{`<svg viewBox="0 0 30 20"></svg>`, 30, 20, "viewBox"},
+		{`<svg width="100%" height="100%" viewBox="0,0,30,20"></svg>`, 30, 20, "relative with viewBox"},
+		{`<?xml version="1.0"?><!-- logo --><svg width="10" height="20"></svg>`, 10, 20, "xml header"},
+	}
+	for _, test := range cases {
+		width, height, err := svgSize([]byte(test.svg))
+		assert.Nil(t, err, test.description)
+		assert.Equal(t, test.width, width, test.description)
+		assert.Equal(t, test.height, height, test.description)
+	}
+}
+
+func TestSVGSizeFail(t *testing.T) {
+	_, _, err := svgSize([]byte(`<svg width="100%"></svg>`))
+	assert.NotNil(t, err, "without dimensions")
+	_, _, err = svgSize([]byte(`<html></html>`))
+	assert.NotNil(t, err, "not svg")
+}
+
+func TestSVGScale(t *testing.T) {
+	buf, _ := ioutil.ReadFile("testdata/logo.svg")
+	scale, _ := svgScale(buf, 400, 0, 0)
+	assert.Equal(t, 4.0, scale)
+	scale, _ = svgScale(buf, 400, 400, 0)
+	assert.Equal(t, 8.0, scale, "covers both dimensions")
+	scale, _ = svgScale(buf, 0, 0, 0)
+	assert.Equal(t, 1.0, scale)
+	scale, _ = svgScale(buf, 0, 0, 144)
+	assert.Equal(t, 1.0, scale, "small svgs keep density")
+}
+
+func TestSVGScaleLimit(t *testing.T) {
+	buf, _ := ioutil.ReadFile("testdata/logo.svg")
+	scale, _ := svgScale(buf, 100000, 0, 0)
+	assert.InDelta(t, float64(maxSVGPixels), 100*50*scale*scale, 1, "scaled down to the limit")
+	scale, _ = svgScale(buf, 10000, 0, 600)
+	assert.InDelta(t, float64(maxSVGPixels), 100*50*math.Pow(scale*600/72, 2), 1, "density counts")
+	scale, _ = svgScale([]byte(`<svg width="100000" height="100000"></svg>`), 0, 0, 0)
+	assert.InDelta(t, float64(maxSVGPixels), 1e10*scale*scale, 1, "big intrinsic size")
+}
+
+func TestJobProcessSVG(t *testing.T) {
+	job := NewJob()
+	err := job.parseFilters("w_400,c_fit,f_png")
+	assert.Nil(t, err)
+	r, _ := os.Open("testdata/logo.svg")
+	job.Source.Load(r)
+
+	err = job.Process(nil)
+	assert.Nil(t, err)
+	size, _ := bimg.NewImage(job.Target.RawContent).Size()
+	assert.Equal(t, 400, size.Width)
+	assert.Equal(t, 200, size.Height)
+}
@@ -0,0 +1,116 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/h2non/bimg"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomedium"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// limits of text layers: font size, characters of text and pixels of the
+// rendered layer
+const (
+	maxFontSize   = 500
+	maxTextLength = 200
+	maxTextPixels = 4000000
+)
+
+// fonts are bundled with the binary to render text layers
+var fonts = map[string][]byte{
+	"go":             goregular.TTF,
+	"go-bold":        gobold.TTF,
+	"go-italic":      goitalic.TTF,
+	"go-bold-italic": gobolditalic.TTF,
+	"go-medium":      gomedium.TTF,
+	"go-mono":        gomono.TTF,
+	"go-mono-bold":   gomonobold.TTF,
+}
+
+// textLayer is a layer defined as text:font_size:text
+type textLayer struct {
+	font string
+	size int
+	text string
+}
+
+// parseText reads a text layer definition without the text: prefix. Text
+// is unescaped, so commas and slashes can be sent escaped twice.
+func parseText(s string) (*textLayer, error) {
+	parts := strings.SplitN(s, ":", 2)
+	style := strings.SplitN(parts[0], "_", 2)
+	if len(parts) != 2 || len(style) != 2 {
+		return nil, fmt.Errorf("text layer \"%s\" not allowed", s)
+	}
+	if _, ok := fonts[style[0]]; !ok {
+		return nil, fmt.Errorf("font \"%s\" not allowed", style[0])
+	}
+	size, err := strconv.Atoi(style[1])
+	if err != nil {
+		return nil, fmt.Errorf("font size is not integer: %v", err)
+	}
+	if size < 1 || size > maxFontSize {
+		return nil, fmt.Errorf("font size %d not allowed", size)
+	}
+	text, err := url.PathUnescape(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("can't unescape text: %v", err)
+	}
+	if text == "" {
+		return nil, fmt.Errorf("text layer is empty")
+	}
+	if length := utf8.RuneCountInString(text); length > maxTextLength {
+		return nil, fmt.Errorf("text of %d characters not allowed", length)
+	}
+	return &textLayer{font: style[0], size: size, text: text}, nil
+}
+
+// render draws the text in a transparent png as tight as the font allows
+func (layer *textLayer) render(c bimg.Color) ([]byte, error) {
+	parsed, err := opentype.Parse(fonts[layer.font])
+	if err != nil {
+		return nil, fmt.Errorf("can't load font: %v", err)
+	}
+	face, err := opentype.NewFace(parsed, &opentype.FaceOptions{
+		Size:    float64(layer.size),
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't load font: %v", err)
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	width := font.MeasureString(face, layer.text).Ceil()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("text layer is empty")
+	}
+	if width*height > maxTextPixels {
+		return nil, fmt.Errorf("text layer of %dx%d not allowed", width, height)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	drawer := font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.RGBA{R: c.R, G: c.G, B: c.B, A: 255}),
+		Face: face,
+		Dot:  fixed.Point26_6{Y: metrics.Ascent},
+	}
+	drawer.DrawString(layer.text)
+	return encodePNG(img)
+}
@@ -0,0 +1,78 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/h2non/bimg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseText(t *testing.T) {
+	cases := []struct {
+		text        string
+		expected    *textLayer
+		err         error
+		description string
+	}{
+		{"go-bold_40:Hello world", &textLayer{"go-bold", 40, "Hello world"}, nil, "text"},
+		{"go_12:Hello%2C world%2F", &textLayer{"go", 12, "Hello, world/"}, nil, "escaped text"},
+		{"go_12:a:b", &textLayer{"go", 12, "a:b"}, nil, "colon in text"},
+		{"go_12", nil, errors.New("text layer \"go_12\" not allowed"), "without text"},
+		{"go:Hello", nil, errors.New("text layer \"go:Hello\" not allowed"), "without size"},
+		{"comic_12:Hello", nil, errors.New("font \"comic\" not allowed"), "font not bundled"},
+		{"go_1000:Hello", nil, errors.New("font size 1000 not allowed"), "too big"},
+		{"go_12:", nil, errors.New("text layer is empty"), "empty text"},
+		{"go_12:" + strings.Repeat("a", maxTextLength+1), nil, fmt.Errorf("text of %d characters not allowed", maxTextLength+1), "long text"},
+	}
+	for _, test := range cases {
+		text, err := parseText(test.text)
+		assert.Equal(t, test.err, err, test.description)
+		assert.Equal(t, test.expected, text, test.description)
+	}
+}
+
+func TestTextRender(t *testing.T) {
+	text := &textLayer{"go-bold", 40, "Hello"}
+	buf, err := text.render(namedColors["red"])
+	assert.Nil(t, err)
+	img, err := decodeRGBA(buf)
+	assert.Nil(t, err)
+	assert.True(t, img.Rect.Dx() > 80, "wide enough for the text")
+	assert.True(t, img.Rect.Dy() >= 40, "high enough for the font")
+
+	var red, transparent int
+	for i := 0; i < len(img.Pix); i += 4 {
+		switch {
+		case img.Pix[i+3] == 0:
+			transparent++
+		case img.Pix[i+3] == 255 && img.Pix[i] == 255 && img.Pix[i+1] == 0:
+			red++
+		}
+	}
+	assert.True(t, red > 0, "text is drawn with color")
+	assert.True(t, transparent > 0, "background is transparent")
+}
+
+func TestTextRenderTooBig(t *testing.T) {
+	text := &textLayer{"go", maxFontSize, strings.Repeat("W", maxTextLength)}
+	_, err := text.render(namedColors["red"])
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "not allowed")
+}
+
+func TestJobProcessText(t *testing.T) {
+	job := NewJob()
+	err := job.parseFilters("w_600,c_fit/l_text:go-bold_40:Hello,co_white,g_south_west,x_20,y_20,f_png")
+	assert.Nil(t, err)
+	r, _ := os.Open("testdata/fiveyears.jpg")
+	job.Source.Load(r)
+
+	err = job.Process(nil)
+	assert.Nil(t, err)
+	size, _ := bimg.NewImage(job.Target.RawContent).Size()
+	assert.Equal(t, 600, size.Width, "base dimensions are kept")
+}
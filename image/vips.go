@@ -0,0 +1,93 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// vipsTimeout limits every run of the vips command line tool
+const vipsTimeout = 30 * time.Second
+
+// vipsSlots bounds the runs of the vips command line tool at once
+var vipsSlots = make(chan struct{}, runtime.NumCPU())
+
+// vipsCopy converts buffer with the vips command line tool, used for
+// features bimg does not expose like multiple frames or pages. Input and
+// output are file names with load and save options, eg input.gif[n=-1].
+func vipsCopy(ctx context.Context, buf []byte, input, output string) ([]byte, error) {
+	read := func(dir string) ([]byte, error) {
+		return ioutil.ReadFile(filepath.Join(dir, withoutOptions(output)))
+	}
+	return vipsRun(ctx, buf, input, read, "vips", "copy", input, output)
+}
+
+// vipsSize reads the dimensions of buffer saved as input, with load
+// options, without decoding it
+func vipsSize(ctx context.Context, buf []byte, input string) (int, int, error) {
+	out, err := vipsRun(ctx, buf, input, nil, "vipsheader", input)
+	if err != nil {
+		return 0, 0, err
+	}
+	// output is like input.pdf: 200x100 uchar, 4 bands, srgb, pdfload
+	var width, height int
+	header := string(out)
+	if i := strings.Index(header, ": "); i >= 0 {
+		header = header[i+2:]
+	}
+	if _, err = fmt.Sscanf(header, "%dx%d", &width, &height); err != nil {
+		return 0, 0, fmt.Errorf("vipsheader: unexpected output %s", strings.TrimSpace(string(out)))
+	}
+	return width, height, nil
+}
+
+// vipsRun runs a tool of vips with buffer saved as input in a temporary
+// working directory. Read gets results from that directory, the output of
+// the tool is returned without it. Runs wait for a free slot and are
+// killed when the context is done or after vipsTimeout.
+func vipsRun(ctx context.Context, buf []byte, input string, read func(dir string) ([]byte, error), name string, args ...string) ([]byte, error) {
+	select {
+	case vipsSlots <- struct{}{}:
+		defer func() { <-vipsSlots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	ctx, cancel := context.WithTimeout(ctx, vipsTimeout)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "godinary")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err = ioutil.WriteFile(filepath.Join(dir, withoutOptions(input)), buf, 0600); err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err = cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %v %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	if read == nil {
+		return stdout.Bytes(), nil
+	}
+	return read(dir)
+}
+
+// withoutOptions removes vips options from a file name
+func withoutOptions(name string) string {
+	if i := strings.Index(name, "["); i >= 0 {
+		return name[:i]
+	}
+	return name
+}